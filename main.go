@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	certificate "mixproxy/src/certs"
 	"mixproxy/src/proxy"
 	"mixproxy/src/proxy/config"
 	"os"
@@ -29,15 +30,34 @@ func main() {
 
 		if len(os.Args) >= 2 {
 			if os.Args[1] == "--start-proxy" {
+				handleReloadSignal()
 				proxy.Control("start")
 
 				return
 			}
+
+			if os.Args[1] == "--validate" {
+				cfg, err := config.ReadConfig()
+				if err != nil {
+					fmt.Println("❌", err)
+					os.Exit(1)
+				}
+				if err := config.ValidateConfig(cfg); err != nil {
+					fmt.Println("❌", err)
+					os.Exit(1)
+				}
+				os.Exit(0)
+			}
+
+			if os.Args[1] == "--export-ca" {
+				exportCA(os.Args[2:])
+				os.Exit(0)
+			}
 		}
 
 		prompt := promptui.Select{
 			Label: "Select",
-			Items: []string{"Start proxy", "Verificate proxy.config.json", "Create certificates SSL (developer)"},
+			Items: []string{"Start proxy", "Verificate proxy.config.json", "Create certificates SSL (developer)", "Export development CA certificate"},
 		}
 
 		_, result, err := prompt.Run()
@@ -49,6 +69,7 @@ func main() {
 
 		switch result {
 		case "Start proxy":
+			handleReloadSignal()
 			proxy.Control("start")
 		case "Verificate proxy.config.json":
 			cfg, _ := config.ReadConfig()
@@ -57,8 +78,49 @@ func main() {
 		case "Create certificates SSL (developer)":
 			proxy.Control("createCertificates")
 			os.Exit(0)
+		case "Export development CA certificate":
+			exportCA(nil)
+			os.Exit(0)
 		}
 	}()
 
 	<-signalChan
 }
+
+// handleReloadSignal reloads the certificates on SIGHUP. It is only set up
+// when the proxy starts, once the configuration has been read.
+func handleReloadSignal() {
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+
+	go func() {
+		for range reloadChan {
+			proxy.Control("reloadCertificates")
+		}
+	}()
+}
+
+// exportCA prints the development CA certificate, or writes it to the path
+// given as first argument.
+func exportCA(args []string) {
+	if len(args) == 0 {
+		if err := certificate.ExportCA(os.Stdout); err != nil {
+			fmt.Println("Error exporting CA:", err)
+		}
+		return
+	}
+
+	file, err := os.Create(args[0])
+	if err != nil {
+		fmt.Println("Error exporting CA:", err)
+		return
+	}
+	defer file.Close()
+
+	if err := certificate.ExportCA(file); err != nil {
+		fmt.Println("Error exporting CA:", err)
+		return
+	}
+
+	fmt.Println("✅ CA certificate exported to " + args[0])
+}
@@ -3,10 +3,17 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	certificate "mixproxy/src/certs"
 	"mixproxy/src/logger"
+	"mixproxy/src/proxy/cachestats"
 	"mixproxy/src/proxy/config"
+	"mixproxy/src/proxy/sessions"
+	"mixproxy/src/proxy/tools"
+	"mixproxy/src/proxy/warming"
 	"mixproxy/src/redis"
+	neturl "net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,9 +28,38 @@ type ConfigResponse struct {
 	ModeDeveloper       bool                       `json:"mode_developer"`
 	LoadBalancer        []config.LoadBalancerEntry `json:"load_balancer"`
 	RootLoadBalancer    *config.LoadBalancerEntry  `json:"root_load_balancer,omitempty"`
+	Listeners           []config.ListenerEntry     `json:"listeners,omitempty"`
+	TLS                 *config.TLSEntry           `json:"tls,omitempty"`
+	L1Cache             *config.L1CacheEntry       `json:"l1_cache,omitempty"`
+	// CertExpiryWarningDays is returned so clients can send it back untouched.
+	CertExpiryWarningDays int `json:"cert_expiry_warning_days,omitempty"`
+}
+
+// CacheStatsResponse adds what the subdomain currently takes in Redis to the
+// counters of this proxy.
+type CacheStatsResponse struct {
+	cachestats.Stats
+	Entries int   `json:"entries"`
+	Size    int64 `json:"size"`
+}
+
+// CacheEntryResponse is a cached response without its body, which is only
+// included on request.
+type CacheEntryResponse struct {
+	Key      string               `json:"key"`
+	TTL      int64                `json:"ttl"`
+	BodySize int                  `json:"body_size"`
+	Response redis.CachedResponse `json:"response"`
+}
+
+// WarmRequest overrides the URLs and sitemap configured in cache.warm.
+type WarmRequest struct {
+	URLs    []string `json:"urls,omitempty"`
+	Sitemap string   `json:"sitemap,omitempty"`
 }
 
 var controlFunc func(string)
+var warmFunc func(subdomain string, urls []string, sitemap string) error
 var cfg *config.Config
 
 func init() {
@@ -34,9 +70,15 @@ func SetControlFunc(f func(string)) {
 	controlFunc = f
 }
 
+// SetWarmFunc sets what starts the cache warming of a subdomain; empty urls
+// and sitemap use the ones in the configuration.
+func SetWarmFunc(f func(subdomain string, urls []string, sitemap string) error) {
+	warmFunc = f
+}
+
 func adminApiMiddleware(c *fiber.Ctx) error {
 	hostAndPort := string(c.BaseURL())
-	host := strings.Split(hostAndPort, "//")[1]
+	host := tools.StripPort(strings.Split(hostAndPort, "//")[1])
 	subdomain := ""
 
 	if host != cfg.Hostname {
@@ -60,8 +102,8 @@ func adminApiMiddleware(c *fiber.Ctx) error {
 	return c.Next()
 }
 
-func HandleAdminAPI() {
-	api := config.SERVERS["HTTPS"].Group("/api")
+func HandleAdminAPI(app *fiber.App) {
+	api := app.Group("/api")
 	api.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
 		AllowMethods: "GET,POST,PUT,OPTIONS,DELETE",
@@ -136,11 +178,187 @@ func HandleAdminAPI() {
 	api.Get("/stats", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
 			"totalRequests":     0,
-			"activeConnections": 0,
+			"activeConnections": sessions.Count(),
 			"uniqueIPs":         0,
 		})
 	})
 
+	api.Get("/websockets", func(c *fiber.Ctx) error {
+		return c.JSON(sessions.List())
+	})
+
+	api.Delete("/websockets/subdomain/:subdomain", func(c *fiber.Ctx) error {
+		closed := sessions.CloseSubdomain(c.Params("subdomain"))
+		return c.JSON(fiber.Map{"status": "ok", "closed": closed})
+	})
+
+	api.Delete("/websockets/subdomain/", func(c *fiber.Ctx) error {
+		closed := sessions.CloseSubdomain("")
+		return c.JSON(fiber.Map{"status": "ok", "closed": closed})
+	})
+
+	api.Delete("/websockets/:id", func(c *fiber.Ctx) error {
+		if !sessions.CloseByID(c.Params("id")) {
+			return c.Status(404).JSON(fiber.Map{"error": "Session not found"})
+		}
+		return c.JSON(fiber.Map{"status": "ok"})
+	})
+
+	// DELETE /api/cache?subdomain=app&url=/page purges one URL; prefix=/blog/
+	// or glob=/blog/*.html purge every matching URL of the subdomain.
+	api.Delete("/cache", func(c *fiber.Ctx) error {
+		subdomain := c.Query("subdomain")
+		url := c.Query("url")
+
+		// También se acepta la URL completa, de la que sale el subdominio
+		if parsed, err := neturl.Parse(url); err == nil && parsed.Host != "" {
+			subdomain = ""
+			if host := tools.StripPort(parsed.Host); host != cfg.Hostname {
+				subdomain = strings.Split(host, ".")[0]
+			}
+			url = parsed.RequestURI()
+		}
+
+		var purged int
+		var err error
+		switch {
+		case url != "":
+			purged, err = redis.PurgeURL(subdomain, url)
+		case c.Query("prefix") != "":
+			purged, err = redis.PurgePrefix(subdomain, c.Query("prefix"))
+		case c.Query("glob") != "":
+			purged, err = redis.PurgeGlob(subdomain, c.Query("glob"))
+		default:
+			return c.Status(400).JSON(fiber.Map{"error": "url, prefix or glob is required"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(fiber.Map{"status": "ok", "purged": purged})
+	})
+
+	api.Delete("/cache/subdomain/:subdomain", func(c *fiber.Ctx) error {
+		purged, err := redis.PurgeSubdomain(c.Params("subdomain"))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"status": "ok", "purged": purged})
+	})
+
+	api.Delete("/cache/subdomain/", func(c *fiber.Ctx) error {
+		purged, err := redis.PurgeSubdomain("")
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"status": "ok", "purged": purged})
+	})
+
+	api.Delete("/cache/tags/:tag", func(c *fiber.Ctx) error {
+		purged, err := redis.PurgeTag(c.Params("tag"))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"status": "ok", "purged": purged})
+	})
+
+	// GET /api/cache/stats returns hits, misses, stale serves, bypasses and
+	// stored bytes of every subdomain with the cache enabled or with traffic.
+	api.Get("/cache/stats", func(c *fiber.Ctx) error {
+		subdomains := map[string]bool{}
+		for _, e := range cfg.LoadBalancer {
+			if e.CacheEnabled {
+				subdomains[e.Subdomain] = true
+			}
+		}
+		if cfg.RootLoadBalancer != nil && cfg.RootLoadBalancer.CacheEnabled {
+			subdomains[""] = true
+		}
+
+		stats := []cachestats.Stats{}
+		for _, s := range cachestats.List() {
+			stats = append(stats, s)
+			delete(subdomains, s.Subdomain)
+		}
+		for subdomain := range subdomains {
+			stats = append(stats, cachestats.Get(subdomain))
+		}
+
+		response := []CacheStatsResponse{}
+		for _, s := range stats {
+			entries, size, err := redis.CacheUsage(s.Subdomain)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			response = append(response, CacheStatsResponse{Stats: s, Entries: entries, Size: size})
+		}
+
+		return c.JSON(response)
+	})
+
+	// GET /api/cache/keys?subdomain=app&prefix=/blog/&limit=100 lists cached
+	// keys with their TTL; the returned cursor gets the next page.
+	api.Get("/cache/keys", func(c *fiber.Ctx) error {
+		cursor, err := strconv.ParseUint(c.Query("cursor", "0"), 10, 64)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid cursor"})
+		}
+		limit, err := strconv.Atoi(c.Query("limit", "100"))
+		if err != nil || limit < 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid limit"})
+		}
+
+		keys, next, err := redis.ListCacheKeys(c.Query("subdomain"), c.Query("prefix"), cursor, limit)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(fiber.Map{"keys": keys, "cursor": next})
+	})
+
+	// GET /api/cache/key?key=cache:app:GET:/page shows a cached response;
+	// body=true includes the body (base64).
+	api.Get("/cache/key", func(c *fiber.Ctx) error {
+		key := c.Query("key")
+		if key == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "key is required"})
+		}
+
+		resp, ttl, found, err := redis.GetCacheEntry(key)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if !found {
+			return c.Status(404).JSON(fiber.Map{"error": "Key not found"})
+		}
+
+		if ttl > 0 {
+			ttl /= time.Second
+		}
+		response := CacheEntryResponse{Key: key, TTL: int64(ttl), BodySize: len(resp.Body), Response: resp}
+		if !c.QueryBool("body") {
+			response.Response.Body = nil
+		}
+
+		return c.JSON(response)
+	})
+
+	// POST /api/cache/warm/:subdomain requests the URLs of cache.warm, or the
+	// urls and sitemap of the body, so they get cached.
+	api.Post("/cache/warm/:subdomain", func(c *fiber.Ctx) error {
+		return startCacheWarming(c, c.Params("subdomain"))
+	})
+
+	api.Post("/cache/warm/", func(c *fiber.Ctx) error {
+		return startCacheWarming(c, "")
+	})
+
+	// GET /api/cache/warm shows the progress of the last warming of each
+	// subdomain.
+	api.Get("/cache/warm", func(c *fiber.Ctx) error {
+		return c.JSON(warming.List())
+	})
+
 	api.Get("/requests", func(c *fiber.Ctx) error {
 		return c.JSON([]fiber.Map{})
 	})
@@ -158,10 +376,48 @@ func HandleAdminAPI() {
 			ModeDeveloper:       cfg.ModeDeveloper,
 			LoadBalancer:        cfg.LoadBalancer,
 			RootLoadBalancer:    cfg.RootLoadBalancer,
+			Listeners:           cfg.Listeners,
+			TLS:                 cfg.TLS,
+			L1Cache:             cfg.L1Cache,
+
+			CertExpiryWarningDays: cfg.CertExpiryWarningDays,
 		}
 		return c.JSON(response)
 	})
 
+	api.Get("/certificates", func(c *fiber.Ctx) error {
+		cfg, err := config.ReadConfig()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to read config",
+			})
+		}
+
+		days := cfg.CertExpiryWarningDays
+		if days == 0 {
+			days = 30
+		}
+		days = c.QueryInt("days", days)
+
+		hostnames := []string{}
+		if cfg.OnHTTPS {
+			hostnames = append(hostnames, cfg.Hostname, cfg.SubdomainAdminPanel+"."+cfg.Hostname, "admin-api."+cfg.Hostname)
+			for _, lb := range cfg.LoadBalancer {
+				if lb.Subdomain != "" {
+					hostnames = append(hostnames, lb.Subdomain+"."+cfg.Hostname)
+				}
+			}
+		}
+
+		certificates, warnings := certificate.Store.Inventory(hostnames, time.Duration(days)*24*time.Hour)
+
+		return c.JSON(fiber.Map{
+			"certificates": certificates,
+			"warnings":     warnings,
+			"warning_days": days,
+		})
+	})
+
 	api.Put("/config", func(c *fiber.Ctx) error {
 		var newCfg config.Config
 
@@ -171,13 +427,6 @@ func HandleAdminAPI() {
 				"details": err.Error(),
 			})
 		}
-		// Validate the config
-		if err := config.ValidateConfig(&newCfg); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error":   "Configuración inválida",
-				"details": err.Error(),
-			})
-		}
 
 		// Read current config for comparison
 		oldCfg, err := config.ReadConfig()
@@ -187,6 +436,28 @@ func HandleAdminAPI() {
 			})
 		}
 
+		// Settings the admin panel doesn't edit are kept when omitted
+		if newCfg.Listeners == nil {
+			newCfg.Listeners = oldCfg.Listeners
+		}
+		if newCfg.TLS == nil {
+			newCfg.TLS = oldCfg.TLS
+		}
+		if newCfg.L1Cache == nil {
+			newCfg.L1Cache = oldCfg.L1Cache
+		}
+		if newCfg.CertExpiryWarningDays == 0 {
+			newCfg.CertExpiryWarningDays = oldCfg.CertExpiryWarningDays
+		}
+
+		// Validate the config
+		if err := config.ValidateConfig(&newCfg); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Configuración inválida",
+				"details": err.Error(),
+			})
+		}
+
 		// Find removed subdomains
 		removedSubdomains := []string{}
 		for _, oldLb := range oldCfg.LoadBalancer {
@@ -570,3 +841,18 @@ func HandleAdminAPI() {
 	})
 
 }
+
+func startCacheWarming(c *fiber.Ctx, subdomain string) error {
+	var req WarmRequest
+	if len(c.Body()) != 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	if err := warmFunc(subdomain, req.URLs, req.Sitemap); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"status": "Processing"})
+}
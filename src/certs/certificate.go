@@ -3,37 +3,248 @@
 package certificate
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"math/big"
+	"net"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
-func Create(DNSnames []string) {
+// The development CA lives inside certs so the container sees it, but in its
+// own folder so the Loader doesn't serve it as a server certificate.
+var CACertFile = filepath.Join("certs", "ca", "rootCA.pem")
+var CAKeyFile = filepath.Join("certs", "ca", "rootCA-key.pem")
+
+// Create issues a development certificate for DNSnames, the first of them
+// being its common name, signed by the development CA.
+func Create(DNSnames []string) error {
+	if len(DNSnames) == 0 {
+		return fmt.Errorf("no names to create the certificate for")
+	}
+
 	os.MkdirAll("certs", 0755)
 
-	// Install mkcert CA if not already installed
-	installCmd := exec.Command("mkcert", "-install")
-	installErr := installCmd.Run()
-	if installErr != nil {
-		fmt.Printf("Error installing mkcert CA: %v\n", installErr)
-		fmt.Println("Please install mkcert: https://github.com/FiloSottile/mkcert")
-		return
+	caCert, caKey, created, err := loadOrCreateCA()
+	if err != nil {
+		return fmt.Errorf("preparing development CA: %w", err)
 	}
 
-	// Use mkcert to generate trusted certificates
-	args := []string{"-cert-file", "./certs/localhost.pem", "-key-file", "./certs/localhost-key.pem"}
-	args = append(args, DNSnames...)
+	if created {
+		fmt.Println("✅ Generated development CA in " + CACertFile)
+	} else {
+		fmt.Println("✅ Using existing development CA in " + CACertFile)
+	}
 
-	fmt.Println(strings.Join(args, " "))
-	cmd := exec.Command("mkcert", args...)
-	err := cmd.Run()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
-		fmt.Printf("Error running mkcert: %v\n", err)
-		fmt.Println("Please install mkcert: https://github.com/FiloSottile/mkcert")
-		return
+		return fmt.Errorf("generating certificate key: %w", err)
 	}
 
-	fmt.Println("✅ Generated trusted certificates with mkcert")
+	serial, err := randomSerial()
+	if err != nil {
+		return fmt.Errorf("generating certificate serial: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"MixProxy development certificate"},
+			CommonName:   DNSnames[0],
+		},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    minTime(time.Now().AddDate(2, 0, 0), caCert.NotAfter),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	for _, name := range DNSnames {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("signing certificate: %w", err)
+	}
+
+	if err := writeCertificate("./certs/localhost.pem", der); err != nil {
+		return fmt.Errorf("writing certificate: %w", err)
+	}
+	if err := writeKey("./certs/localhost-key.pem", key); err != nil {
+		return fmt.Errorf("writing certificate key: %w", err)
+	}
+
+	fmt.Println("✅ Generated development certificates")
 	fmt.Println("✅ " + strings.Join(DNSnames, "\n✅ "))
+
+	if created {
+		fmt.Println()
+		fmt.Println("Trust the development CA once so browsers accept the certificates:")
+		fmt.Println("  Linux:   sudo cp " + CACertFile + " /usr/local/share/ca-certificates/mixproxy.crt && sudo update-ca-certificates")
+		fmt.Println("  macOS:   sudo security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain " + CACertFile)
+		fmt.Println("  Windows: certutil -addstore -f ROOT " + CACertFile)
+	}
+
+	return nil
+}
+
+// ExportCA writes the development CA certificate to w.
+func ExportCA(w io.Writer) error {
+	data, err := os.ReadFile(CACertFile)
+	if err != nil {
+		return fmt.Errorf("development CA not found, create the certificates first: %w", err)
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// loadOrCreateCA returns the development CA, creating it the first time and
+// again once it has expired. A CA that can't be read is an error rather than
+// being replaced, as browsers may already trust it.
+func loadOrCreateCA() (*x509.Certificate, crypto.Signer, bool, error) {
+	if _, err := os.Stat(CACertFile); err == nil {
+		cert, key, err := loadCA()
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("%w (remove %s to generate a new CA)", err, filepath.Dir(CACertFile))
+		}
+		if time.Now().Before(cert.NotAfter) {
+			return cert, key, false, nil
+		}
+
+		fmt.Printf("⚠️  Development CA in %s expired on %s, generating a new one\n", CACertFile, cert.NotAfter.Format(time.DateOnly))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(CACertFile), 0700); err != nil {
+		return nil, nil, false, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"MixProxy development CA"},
+			CommonName:   "MixProxy development CA " + hostname,
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if err := writeCertificate(CACertFile, der); err != nil {
+		return nil, nil, false, err
+	}
+	if err := writeKey(CAKeyFile, key); err != nil {
+		return nil, nil, false, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	return cert, key, true, nil
+}
+
+func loadCA() (*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := os.ReadFile(CACertFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("invalid PEM in %s", CACertFile)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !cert.IsCA {
+		return nil, nil, fmt.Errorf("%s is not a CA certificate", CACertFile)
+	}
+	if time.Now().Before(cert.NotBefore) {
+		return nil, nil, fmt.Errorf("%s is not valid until %s", CACertFile, cert.NotBefore.Format(time.DateOnly))
+	}
+
+	keyPEM, err := os.ReadFile(CAKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("invalid PEM in %s", CAKeyFile)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported key type in %s", CAKeyFile)
+	}
+	if !publicKeysEqual(cert.PublicKey, signer.Public()) {
+		return nil, nil, fmt.Errorf("%s doesn't match %s", CAKeyFile, CACertFile)
+	}
+
+	return cert, signer, nil
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func writeCertificate(path string, der []byte) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+func writeKey(path string, key crypto.Signer) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
 }
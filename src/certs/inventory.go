@@ -0,0 +1,69 @@
+package certificate
+
+import (
+	"fmt"
+	"time"
+)
+
+type CertificateInfo struct {
+	Subject       string    `json:"subject"`
+	SANs          []string  `json:"sans"`
+	Issuer        string    `json:"issuer"`
+	NotBefore     time.Time `json:"not_before"`
+	NotAfter      time.Time `json:"not_after"`
+	DaysRemaining int       `json:"days_remaining"`
+	SourceFile    string    `json:"source_file"`
+	KeyFile       string    `json:"key_file"`
+}
+
+// Inventory describes the certificates served by the loader and warns about
+// those expiring within window and about hostnames no certificate covers.
+func (l *Loader) Inventory(hostnames []string, window time.Duration) ([]CertificateInfo, []string) {
+	infos := []CertificateInfo{}
+	warnings := []string{}
+	now := time.Now()
+
+	loaded := l.Certificates()
+	if len(loaded) == 0 {
+		return infos, append(warnings, "no certificates loaded")
+	}
+
+	for _, c := range loaded {
+		sans := append([]string{}, c.Leaf.DNSNames...)
+		for _, ip := range c.Leaf.IPAddresses {
+			sans = append(sans, ip.String())
+		}
+
+		infos = append(infos, CertificateInfo{
+			Subject:       c.Leaf.Subject.String(),
+			SANs:          sans,
+			Issuer:        c.Leaf.Issuer.String(),
+			NotBefore:     c.Leaf.NotBefore,
+			NotAfter:      c.Leaf.NotAfter,
+			DaysRemaining: int(c.Leaf.NotAfter.Sub(now).Hours() / 24),
+			SourceFile:    c.CertFile,
+			KeyFile:       c.KeyFile,
+		})
+
+		if now.After(c.Leaf.NotAfter) {
+			warnings = append(warnings, fmt.Sprintf("%s expired on %s", c.CertFile, c.Leaf.NotAfter.Format(time.DateOnly)))
+		} else if c.Leaf.NotAfter.Sub(now) < window {
+			warnings = append(warnings, fmt.Sprintf("%s expires on %s", c.CertFile, c.Leaf.NotAfter.Format(time.DateOnly)))
+		}
+	}
+
+	for _, hostname := range hostnames {
+		covered := false
+		for _, c := range loaded {
+			if c.Leaf.VerifyHostname(hostname) == nil {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			warnings = append(warnings, fmt.Sprintf("no certificate covers %s", hostname))
+		}
+	}
+
+	return infos, warnings
+}
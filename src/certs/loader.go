@@ -0,0 +1,208 @@
+package certificate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoadedCertificate is a key pair read from the certs directory.
+type LoadedCertificate struct {
+	Certificate *tls.Certificate
+	Leaf        *x509.Certificate
+	CertFile    string
+	KeyFile     string
+}
+
+type certificateSet struct {
+	fallback *LoadedCertificate
+	others   []*LoadedCertificate
+}
+
+// Loader serves the certificates found in a directory and swaps them
+// atomically when the files change, so new handshakes use the renewed
+// certificates while established connections keep the old ones.
+type Loader struct {
+	dir      string
+	certFile string
+	keyFile  string
+
+	current  atomic.Pointer[certificateSet]
+	mu       sync.Mutex
+	modTimes map[string]time.Time
+}
+
+// Store is the loader used by the HTTPS listener.
+var Store = NewLoader("./certs", "localhost.pem", "localhost-key.pem")
+
+func NewLoader(dir, certFile, keyFile string) *Loader {
+	return &Loader{
+		dir:      dir,
+		certFile: certFile,
+		keyFile:  keyFile,
+		modTimes: map[string]time.Time{},
+	}
+}
+
+// Reload reads every "<name>.pem" / "<name>-key.pem" pair in the directory.
+// The default pair is required; other pairs are served when their names
+// match the SNI of the handshake.
+func (l *Loader) Reload() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fallback, err := loadPair(filepath.Join(l.dir, l.certFile), filepath.Join(l.dir, l.keyFile))
+	if err != nil {
+		return err
+	}
+
+	set := &certificateSet{fallback: fallback}
+
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == l.certFile || !strings.HasSuffix(name, ".pem") || strings.HasSuffix(name, "-key.pem") {
+			continue
+		}
+
+		keyFile := strings.TrimSuffix(name, ".pem") + "-key.pem"
+		if _, err := os.Stat(filepath.Join(l.dir, keyFile)); err != nil {
+			continue
+		}
+
+		loaded, err := loadPair(filepath.Join(l.dir, name), filepath.Join(l.dir, keyFile))
+		if err != nil {
+			log.Printf("Skipping certificate %s: %v", name, err)
+			continue
+		}
+		set.others = append(set.others, loaded)
+	}
+
+	l.current.Store(set)
+	l.modTimes = l.scan()
+
+	return nil
+}
+
+// Certificates returns every certificate currently served.
+func (l *Loader) Certificates() []*LoadedCertificate {
+	set := l.current.Load()
+	if set == nil {
+		return nil
+	}
+
+	return append([]*LoadedCertificate{set.fallback}, set.others...)
+}
+
+// GetCertificate is meant to be used as tls.Config.GetCertificate.
+func (l *Loader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	set := l.current.Load()
+	if set == nil {
+		return nil, fmt.Errorf("no certificates loaded")
+	}
+
+	if hello.ServerName != "" {
+		for _, c := range set.others {
+			if c.Leaf.VerifyHostname(hello.ServerName) == nil {
+				return c.Certificate, nil
+			}
+		}
+	}
+
+	return set.fallback.Certificate, nil
+}
+
+// Watch polls the directory and reloads the certificates when a file is
+// added, removed or modified.
+func (l *Loader) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if !l.changed() {
+				continue
+			}
+
+			if err := l.Reload(); err != nil {
+				log.Printf("❌ Error reloading certificates: %v", err)
+				continue
+			}
+			log.Println("🔄 Certificates reloaded")
+		}
+	}()
+}
+
+func (l *Loader) changed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current := l.scan()
+	if len(current) != len(l.modTimes) {
+		return true
+	}
+
+	for name, modTime := range current {
+		if previous, ok := l.modTimes[name]; !ok || !previous.Equal(modTime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *Loader) scan() map[string]time.Time {
+	modTimes := map[string]time.Time{}
+
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return modTimes
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".pem") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		modTimes[entry.Name()] = info.ModTime()
+	}
+
+	return modTimes
+}
+
+func loadPair(certFile, keyFile string) (*LoadedCertificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load key pair %s / %s: %w", certFile, keyFile, err)
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+		cert.Leaf = leaf
+	}
+
+	return &LoadedCertificate{
+		Certificate: &cert,
+		Leaf:        leaf,
+		CertFile:    certFile,
+		KeyFile:     keyFile,
+	}, nil
+}
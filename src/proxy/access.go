@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"log"
+	"mixproxy/src/proxy/config"
+	"mixproxy/src/redis"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// checkAccess runs the access lists of the subdomain against the client IP,
+// for HTTP requests and WebSocket upgrades alike. It returns false when the
+// request has been rejected.
+func checkAccess(c *fiber.Ctx, subdomain string) bool {
+	ip := c.IP()
+
+	if isEnabled, _ := redis.IsEnabledWhitelistForSubdomain(subdomain); isEnabled {
+		if _, err := redis.GetIPForWhitelist(subdomain, ip); err != nil {
+			c.Status(fiber.StatusForbidden).SendString("You are not on the whitelist")
+			return false
+		}
+	}
+
+	// Check global blacklist
+	if _, err := redis.GetIPForGlobalBlacklist(ip); err == nil {
+		c.Status(fiber.StatusForbidden).SendString("You are on the global blacklist")
+		return false
+	}
+
+	if isEnabled, _ := redis.IsEnabledBlacklistForSubdomain(subdomain); isEnabled {
+		if reason, err := redis.GetIPForBlacklist(subdomain, ip); err == nil {
+			c.Status(fiber.StatusForbidden).SendString("You are on the blacklist\n" + reason.Content)
+			return false
+		}
+	}
+
+	if !allowedByRateLimit(subdomain, ip) {
+		c.Status(fiber.StatusTooManyRequests).SendString("Too many requests")
+		return false
+	}
+
+	return true
+}
+
+// allowedByRateLimit counts the request against the rate limit of the
+// subdomain. Routes without rate_limit don't touch Redis, and a Redis error
+// lets the request through.
+func allowedByRateLimit(subdomain, ip string) bool {
+	route, _ := getRoute(subdomain)
+	if route.RateLimit == nil {
+		return true
+	}
+
+	limit, err := route.RateLimit.Limit()
+	if err != nil {
+		log.Printf("Invalid rate_limit configuration for subdomain '%s': %v", subdomain, err)
+		return true
+	}
+
+	allowed, err := redis.AllowRequest(subdomain, ip, limit.Requests, limit.Window, limit.Block)
+	if err != nil {
+		log.Printf("Redis error: %v", err)
+		return true
+	}
+
+	return allowed
+}
+
+// checkAdminAuth asks for the admin credentials before reaching the admin
+// panel. It returns false when the request has been rejected.
+func checkAdminAuth(c *fiber.Ctx) bool {
+	auth := c.Get("Authorization")
+	if auth == "" || !strings.HasPrefix(auth, "Basic ") {
+		c.Status(401).Set("WWW-Authenticate", `Basic realm="Admin"`)
+		c.SendString("Unauthorized")
+		return false
+	}
+	encoded := strings.TrimPrefix(auth, "Basic ")
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		c.Status(401).SendString("Unauthorized")
+		return false
+	}
+	creds := string(decoded)
+	parts := strings.SplitN(creds, ":", 2)
+	if len(parts) != 2 || parts[0] != config.AdminUsername || parts[1] != config.AdminPassword {
+		c.Status(401).SendString("Unauthorized")
+		return false
+	}
+
+	return true
+}
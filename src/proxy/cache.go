@@ -0,0 +1,430 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"mixproxy/src/logger"
+	"mixproxy/src/proxy/cachestats"
+	"mixproxy/src/redis"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// refreshLockTTL bounds how long a background refresh can hold its lock if
+// the proxy dies before releasing it.
+const refreshLockTTL = 30 * time.Second
+
+// lookupCache returns the cached response for the request, following the
+// variant index when the URL was stored with a Vary header. The response may
+// be stale; stale responses are only kept when they can be revalidated. Key
+// is set on misses too, to the variant key when the index is known.
+func lookupCache(c *fiber.Ctx) (redis.CachedResponse, bool) {
+	key := generateCacheKey(c)
+
+	cached, found, err := redis.GetCachedResponse(key)
+	if err != nil {
+		log.Printf("Redis error: %v", err)
+		return redis.CachedResponse{Key: key}, false
+	}
+	if !found {
+		return redis.CachedResponse{Key: key}, false
+	}
+
+	if cached.Status == 0 && len(cached.Vary) != 0 {
+		key = variantCacheKey(c, key, cached.Vary)
+		cached, found, err = redis.GetCachedResponse(key)
+		if err != nil {
+			log.Printf("Redis error: %v", err)
+			return redis.CachedResponse{Key: key}, false
+		}
+		if !found {
+			return redis.CachedResponse{Key: key}, false
+		}
+	}
+
+	cached.Key = key
+	return cached, true
+}
+
+// storeCache saves the upstream response when RFC 9111 allows it and returns
+// it, nil when it wasn't stored. Responses with Vary are stored under a
+// secondary key built from the request headers they depend on, and the URL
+// key keeps the list of those headers.
+func storeCache(c *fiber.Ctx, subdomain string) *redis.CachedResponse {
+	vary, ok := responseVary(c)
+	if !ok {
+		return nil
+	}
+
+	route, _ := getRoute(subdomain)
+	policy, err := route.Cache.Policy()
+	if err != nil {
+		log.Printf("Invalid cache configuration for subdomain '%s': %v", subdomain, err)
+	}
+
+	now := time.Now()
+	ttl, age, ok := responseFreshness(c, policy, getCacheRule(subdomain, c.Path()), now)
+	if !ok {
+		return nil
+	}
+
+	etag := string(c.Response().Header.Peek(fiber.HeaderETag))
+	lastModified := string(c.Response().Header.Peek(fiber.HeaderLastModified))
+
+	// La copia caducada se conserva para revalidarla o servirla si hay errores
+	whileRevalidate, ifError := staleWindows(c, policy)
+	keep := max(whileRevalidate, ifError)
+	if etag != "" || lastModified != "" {
+		keep = max(keep, policy.Keep)
+	}
+	storeTTL := ttl + keep
+	if storeTTL <= 0 {
+		return nil
+	}
+
+	// Las descargas grandes, las de tamaño desconocido y las cortadas no se
+	// leen en memoria ni se guardan
+	body, ok := readUpstreamBody(c, policy.MaxObjectSize)
+	if !ok || int64(len(body)) > policy.MaxObjectSize {
+		return nil
+	}
+
+	key := generateCacheKey(c)
+	resp := redis.CachedResponse{
+		Status:  c.Response().StatusCode(),
+		Body:    append([]byte(nil), body...),
+		Created: now.Add(-age),
+		Expires: now.Add(ttl),
+		Vary:    vary,
+
+		StaleWhileRevalidate: now.Add(ttl + whileRevalidate),
+		StaleIfError:         now.Add(ttl + ifError),
+
+		ETag:         etag,
+		LastModified: lastModified,
+		Tags:         responseTags(c),
+	}
+	c.Response().Header.VisitAll(func(key, value []byte) {
+		if !cacheTagHeaders[string(key)] {
+			resp.Headers = append(resp.Headers, redis.Header{Key: string(key), Value: string(value)})
+		}
+	})
+
+	if len(vary) != 0 {
+		// El índice vive tanto como la variante más duradera
+		indexTTL := max(storeTTL, redis.CacheTTL(key))
+		index := redis.CachedResponse{Vary: vary, Expires: now.Add(indexTTL)}
+		if err := redis.SetCachedResponse(key, index, indexTTL, false); err != nil {
+			log.Printf("Failed to cache response: %v", err)
+			return nil
+		}
+		key = variantCacheKey(c, key, vary)
+	}
+
+	if err := redis.SetCachedResponse(key, resp, storeTTL, policy.CompressStorage); err != nil {
+		log.Printf("Failed to cache response: %v", err)
+		return nil
+	}
+	cachestats.RecordStore(subdomain, int64(len(resp.Body)))
+
+	if err := redis.TagCachedResponse(key, resp.Tags, storeTTL); err != nil {
+		log.Printf("Failed to tag cached response: %v", err)
+	}
+
+	resp.Key = key
+	return &resp
+}
+
+// Response headers with the tags used to purge groups of responses. They are
+// meant for the proxy and never reach the client.
+var cacheTagHeaders = map[string]bool{
+	"Surrogate-Key": true,
+	"Cache-Tag":     true,
+}
+
+// responseTags returns the tags of the response: Surrogate-Key is separated
+// by spaces and Cache-Tag by commas.
+func responseTags(c *fiber.Ctx) []string {
+	tags := strings.Fields(string(c.Response().Header.Peek("Surrogate-Key")))
+	for _, tag := range strings.Split(string(c.Response().Header.Peek("Cache-Tag")), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+func removeCacheTagHeaders(c *fiber.Ctx) {
+	for header := range cacheTagHeaders {
+		c.Response().Header.Del(header)
+	}
+}
+
+// respondFromCache answers the request with a cached response, fresh or
+// stale, and logs it as served from the cache.
+func respondFromCache(c *fiber.Ctx, cached redis.CachedResponse, host, subdomain string, result cachestats.Result) error {
+	err := serveCached(c, cached)
+	setCacheResult(c, subdomain, result)
+
+	// Set Server header for cached response
+	if redis.DoesTheSubdomainAllowCache(subdomain) {
+		c.Set(fiber.HeaderServer, "Mixproxy (with cache)")
+	} else {
+		c.Set(fiber.HeaderServer, "Mixproxy")
+	}
+	logger.AddRequestLog(c.Method(), host+c.OriginalURL(), c.IP(), subdomain, c.Response().StatusCode(), true)
+
+	return err
+}
+
+// canServeStaleIfError reports whether the stale response may replace a
+// failed or 5xx answer from the backend.
+func canServeStaleIfError(stale *redis.CachedResponse) bool {
+	return stale != nil && time.Now().Before(stale.StaleIfError)
+}
+
+// refreshInBackground refetches the requested URL through the proxy while
+// the client gets the stale copy. Only one refresh per URL runs at a time.
+func refreshInBackground(c *fiber.Ctx) {
+	key := generateCacheKey(c)
+	if !redis.LockCacheRefresh(key, refreshLockTTL) {
+		return
+	}
+
+	req := &fasthttp.Request{}
+	c.Request().CopyTo(req)
+	// Un HEAD caducado se refresca con el GET del que sale
+	req.Header.SetMethod(fiber.MethodGet)
+	req.Header.Set(fiber.HeaderCacheControl, "no-cache")
+	req.Header.Del(fiber.HeaderIfNoneMatch)
+	req.Header.Del(fiber.HeaderIfModifiedSince)
+
+	app := c.App()
+	go func() {
+		defer redis.UnlockCacheRefresh(key)
+		doInternalRequest(app, req)
+	}()
+}
+
+// serveCached writes a cached response, or a 304 when the client already has
+// it.
+func serveCached(c *fiber.Ctx, cached redis.CachedResponse) error {
+	c.Status(cached.Status)
+	setCachedHeaders(c, cached.Headers)
+	c.Set(fiber.HeaderAge, strconv.Itoa(int(time.Since(cached.Created).Seconds())))
+
+	if notModified(c) {
+		c.Status(fiber.StatusNotModified)
+		return nil
+	}
+
+	if serveEncoded(c, cached) {
+		return nil
+	}
+
+	return c.Send(cached.Body)
+}
+
+// setCachedHeaders adds stored headers to the response, keeping every value
+// of repeated headers.
+func setCachedHeaders(c *fiber.Ctx, headers []redis.Header) {
+	for _, h := range headers {
+		c.Response().Header.Add(h.Key, h.Value)
+	}
+}
+
+// setCacheResult sends the X-Cache header and counts the result in the
+// stats of the subdomain. Background refreshes aren't counted.
+func setCacheResult(c *fiber.Ctx, subdomain string, result cachestats.Result) {
+	c.Set("X-Cache", string(result))
+	if !isInternalRequest(c) {
+		cachestats.Record(subdomain, result)
+	}
+}
+
+// notModified evaluates the client's If-None-Match or If-Modified-Since
+// against the response about to be sent (RFC 9110 13.2.2).
+func notModified(c *fiber.Ctx) bool {
+	if c.Response().StatusCode() != fiber.StatusOK {
+		return false
+	}
+
+	etag := string(c.Response().Header.Peek(fiber.HeaderETag))
+
+	if ifNoneMatch := c.Get(fiber.HeaderIfNoneMatch); ifNoneMatch != "" {
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || (etag != "" && strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/")) {
+				return true
+			}
+		}
+		return false
+	}
+
+	ifModifiedSince, err := http.ParseTime(c.Get(fiber.HeaderIfModifiedSince))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(string(c.Response().Header.Peek(fiber.HeaderLastModified)))
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.After(ifModifiedSince)
+}
+
+// Headers of a 304 from the backend that describe the empty 304 itself and
+// must not replace those of the cached response.
+var notUpdatedOn304 = map[string]bool{
+	fiber.HeaderContentLength:    true,
+	fiber.HeaderContentType:      true,
+	fiber.HeaderContentEncoding:  true,
+	fiber.HeaderTransferEncoding: true,
+	fiber.HeaderConnection:       true,
+}
+
+// revalidation holds the client's own validators, put aside while the proxy
+// asks the backend whether its stale copy is still valid.
+type revalidation struct {
+	cached          redis.CachedResponse
+	ifNoneMatch     string
+	ifModifiedSince string
+}
+
+func startRevalidation(c *fiber.Ctx, cached redis.CachedResponse) *revalidation {
+	r := &revalidation{
+		cached:          cached,
+		ifNoneMatch:     string(c.Request().Header.Peek(fiber.HeaderIfNoneMatch)),
+		ifModifiedSince: string(c.Request().Header.Peek(fiber.HeaderIfModifiedSince)),
+	}
+
+	c.Request().Header.Del(fiber.HeaderIfNoneMatch)
+	c.Request().Header.Del(fiber.HeaderIfModifiedSince)
+	if cached.ETag != "" {
+		c.Request().Header.Set(fiber.HeaderIfNoneMatch, cached.ETag)
+	}
+	if cached.LastModified != "" {
+		c.Request().Header.Set(fiber.HeaderIfModifiedSince, cached.LastModified)
+	}
+
+	return r
+}
+
+// finish puts the client's validators back and, when the backend answered
+// 304, replaces the response with the cached one updated with the headers of
+// the 304 so it is stored again with a new TTL instead of refetching it. It
+// reports whether the cached response was still valid.
+func (r *revalidation) finish(c *fiber.Ctx) bool {
+	c.Request().Header.Del(fiber.HeaderIfNoneMatch)
+	c.Request().Header.Del(fiber.HeaderIfModifiedSince)
+	if r.ifNoneMatch != "" {
+		c.Request().Header.Set(fiber.HeaderIfNoneMatch, r.ifNoneMatch)
+	}
+	if r.ifModifiedSince != "" {
+		c.Request().Header.Set(fiber.HeaderIfModifiedSince, r.ifModifiedSince)
+	}
+
+	if c.Response().StatusCode() != fiber.StatusNotModified {
+		return false
+	}
+
+	// Las cabeceras del 304 sustituyen a las guardadas con el mismo nombre
+	updated := []redis.Header{}
+	replaced := map[string]bool{}
+	c.Response().Header.VisitAll(func(key, value []byte) {
+		if !notUpdatedOn304[string(key)] {
+			updated = append(updated, redis.Header{Key: string(key), Value: string(value)})
+			replaced[string(key)] = true
+		}
+	})
+
+	headers := []redis.Header{}
+	for _, h := range r.cached.Headers {
+		if !replaced[h.Key] {
+			headers = append(headers, h)
+		}
+	}
+
+	c.Response().Reset()
+	c.Status(r.cached.Status)
+	setCachedHeaders(c, append(headers, updated...))
+	if len(r.cached.Tags) != 0 && !replaced["Surrogate-Key"] {
+		c.Set("Surrogate-Key", strings.Join(r.cached.Tags, " "))
+	}
+	c.Response().SetBody(r.cached.Body)
+
+	return true
+}
+
+// responseVary returns the canonical, sorted list of request headers named by
+// the upstream Vary header. ok is false for "Vary: *", which can't be cached.
+// Accept-Encoding is left out when the proxy compresses the route, as the
+// backend never saw it and its body is the same for every client.
+func responseVary(c *fiber.Ctx) ([]string, bool) {
+	seen := map[string]bool{}
+	vary := []string{}
+
+	if _, ok := getCompression(getSubdomain(c)); ok {
+		seen[fiber.HeaderAcceptEncoding] = true
+	}
+
+	for _, value := range c.Response().Header.PeekAll(fiber.HeaderVary) {
+		for _, name := range strings.Split(string(value), ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil, false
+			}
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			vary = append(vary, name)
+		}
+	}
+
+	sort.Strings(vary)
+	return vary, true
+}
+
+// variantCacheKey appends to key a hash of the request headers listed in
+// vary, so header values such as cookies never show up in cache keys.
+func variantCacheKey(c *fiber.Ctx, key string, vary []string) string {
+	h := sha256.New()
+	for _, name := range vary {
+		h.Write([]byte(name + ":" + normalizeVaryValue(name, c.Get(name)) + "\n"))
+	}
+
+	return key + ":vary:" + hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// normalizeVaryValue makes equivalent header values share a variant, e.g.
+// "gzip, br" and "br,gzip;q=1.0" for Accept-Encoding.
+func normalizeVaryValue(name, value string) string {
+	if name != fiber.HeaderAcceptEncoding {
+		return strings.Join(strings.Fields(value), " ")
+	}
+
+	codings := []string{}
+	for _, part := range strings.Split(value, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok && strings.Trim(q, "0.") == "" {
+			continue
+		}
+		codings = append(codings, coding)
+	}
+
+	sort.Strings(codings)
+	return strings.Join(codings, ",")
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"mixproxy/src/proxy/config"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// cacheControl holds the directives of a Cache-Control header. Directives
+// without an argument map to "".
+type cacheControl map[string]string
+
+func parseCacheControl(value string) cacheControl {
+	cc := cacheControl{}
+
+	for _, part := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+	}
+
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// seconds returns the delta-seconds argument of a directive. An invalid
+// argument counts as 0, which makes the response stale (RFC 9111 4.2.1).
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	arg, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < 0 {
+		return 0, true
+	}
+	if n > int64(365*24*time.Hour/time.Second) {
+		n = int64(365 * 24 * time.Hour / time.Second)
+	}
+
+	return time.Duration(n) * time.Second, true
+}
+
+// Status codes that may be cached without explicit freshness (RFC 9110 15.1).
+var heuristicallyCacheable = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// responseFreshness applies RFC 9111 to the upstream response of a GET as a
+// shared cache. It returns how long the response stays fresh, 0 when it must
+// be revalidated before being used, and the age it already had when it
+// arrived; ok is false when it can't be stored. A TTL forced by the cache
+// rule replaces the freshness given by the backend.
+func responseFreshness(c *fiber.Ctx, policy config.CachePolicy, rule *config.CacheRule, now time.Time) (ttl, age time.Duration, ok bool) {
+	header := &c.Response().Header
+	cc := parseCacheControl(string(header.Peek(fiber.HeaderCacheControl)))
+
+	if cc.has("no-store") || cc.has("private") {
+		return 0, 0, false
+	}
+	if parseCacheControl(c.Get(fiber.HeaderCacheControl)).has("no-store") {
+		return 0, 0, false
+	}
+
+	// Una respuesta que fija cookies es de un usuario concreto
+	if len(header.Peek(fiber.HeaderSetCookie)) != 0 {
+		return 0, 0, false
+	}
+
+	if c.Get(fiber.HeaderAuthorization) != "" && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return 0, 0, false
+	}
+
+	status := header.StatusCode()
+	if status == fiber.StatusPartialContent || status == fiber.StatusNotModified {
+		return 0, 0, false
+	}
+
+	date := now
+	if t, err := http.ParseTime(string(header.Peek(fiber.HeaderDate))); err == nil {
+		date = t
+	}
+
+	if n, err := strconv.Atoi(string(header.Peek(fiber.HeaderAge))); err == nil && n > 0 {
+		age = time.Duration(n) * time.Second
+	}
+	if apparent := now.Sub(date); apparent > age {
+		age = apparent
+	}
+
+	if rule != nil {
+		if forced, ok := rule.ResponseTTL(status); ok {
+			return forced, age, true
+		}
+	}
+
+	var lifetime time.Duration
+	explicit := true
+
+	if cc.has("no-cache") {
+		// Se guarda, pero hay que revalidarla antes de cada uso
+		lifetime = 0
+	} else if maxAge, ok := cc.seconds("s-maxage"); ok {
+		lifetime = maxAge
+	} else if maxAge, ok := cc.seconds("max-age"); ok {
+		lifetime = maxAge
+	} else if expires := header.Peek(fiber.HeaderExpires); len(expires) != 0 {
+		// Un Expires inválido significa que ya ha caducado
+		if t, err := http.ParseTime(string(expires)); err == nil {
+			lifetime = t.Sub(date)
+		}
+	} else {
+		explicit = false
+
+		if policy.DefaultTTL > 0 {
+			lifetime = policy.DefaultTTL
+		} else if t, err := http.ParseTime(string(header.Peek(fiber.HeaderLastModified))); err == nil && t.Before(date) {
+			lifetime = date.Sub(t) / 10
+		} else {
+			lifetime = config.DefaultCacheTTL
+		}
+	}
+
+	if !explicit && !cc.has("public") && !heuristicallyCacheable[status] {
+		return 0, 0, false
+	}
+
+	ttl = lifetime - age
+	if policy.MaxTTL > 0 && ttl > policy.MaxTTL {
+		ttl = policy.MaxTTL
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	return ttl, age, true
+}
+
+// requestAcceptsCached reports whether the client allows being answered with
+// a cached response of the given age that stays fresh until expires.
+func requestAcceptsCached(c *fiber.Ctx, age time.Duration, expires time.Time) bool {
+	cc := parseCacheControl(c.Get(fiber.HeaderCacheControl))
+
+	if cc.has("no-cache") {
+		return false
+	}
+	if len(cc) == 0 && strings.Contains(c.Get(fiber.HeaderPragma), "no-cache") {
+		return false
+	}
+	if maxAge, ok := cc.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := cc.seconds("min-fresh"); ok && time.Until(expires) < minFresh {
+		return false
+	}
+
+	return true
+}
+
+// staleWindows returns how long after expiring the response may be served
+// while it is refreshed in the background, and when the backend fails. The
+// response's stale-while-revalidate and stale-if-error (RFC 5861) win over
+// the route's; must-revalidate and its relatives forbid the route's.
+func staleWindows(c *fiber.Ctx, policy config.CachePolicy) (whileRevalidate, ifError time.Duration) {
+	cc := parseCacheControl(string(c.Response().Header.Peek(fiber.HeaderCacheControl)))
+	strict := cc.has("must-revalidate") || cc.has("proxy-revalidate") || cc.has("no-cache") || cc.has("s-maxage")
+
+	whileRevalidate, ok := cc.seconds("stale-while-revalidate")
+	if !ok && !strict {
+		whileRevalidate = policy.StaleWhileRevalidate
+	}
+
+	ifError, ok = cc.seconds("stale-if-error")
+	if !ok && !strict {
+		ifError = policy.StaleIfError
+	}
+
+	return whileRevalidate, ifError
+}
@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"mixproxy/src/proxy/config"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// newTestCtx returns a fiber context for a request to host and uri.
+func newTestCtx(t *testing.T, host, uri string) *fiber.Ctx {
+	t.Helper()
+
+	if cfg == nil {
+		cfg = &config.Config{Hostname: "dev.space"}
+	}
+
+	app := fiber.New()
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.SetRequestURI(uri)
+	fctx.Request.Header.SetHost(host)
+
+	c := app.AcquireCtx(fctx)
+	t.Cleanup(func() { app.ReleaseCtx(c) })
+
+	return c
+}
+
+// setUpstreamResponse parses the response headers as they come from the
+// backend; fasthttp ignores Date when it is set by hand.
+func setUpstreamResponse(t *testing.T, c *fiber.Ctx, status int, headers map[string]string) {
+	t.Helper()
+
+	if status == 0 {
+		status = fiber.StatusOK
+	}
+
+	raw := fmt.Sprintf("HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	for k, v := range headers {
+		raw += k + ": " + v + "\r\n"
+	}
+	raw += "Content-Length: 0\r\n\r\n"
+
+	if err := c.Response().Header.Read(bufio.NewReader(strings.NewReader(raw))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResponseFreshness(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	date := now.Format(http.TimeFormat)
+
+	tests := []struct {
+		name     string
+		status   int
+		request  map[string]string
+		response map[string]string
+		policy   config.CachePolicy
+		rule     *config.CacheRule
+		wantTTL  time.Duration
+		wantAge  time.Duration
+		wantOK   bool
+	}{
+		{
+			name:     "max-age",
+			response: map[string]string{"Date": date, "Cache-Control": "max-age=60"},
+			wantTTL:  time.Minute,
+			wantOK:   true,
+		},
+		{
+			name:     "s-maxage wins over max-age",
+			response: map[string]string{"Date": date, "Cache-Control": "max-age=60, s-maxage=300"},
+			wantTTL:  5 * time.Minute,
+			wantOK:   true,
+		},
+		{
+			name:     "age is taken off",
+			response: map[string]string{"Date": date, "Cache-Control": "max-age=60", "Age": "10"},
+			wantTTL:  50 * time.Second,
+			wantAge:  10 * time.Second,
+			wantOK:   true,
+		},
+		{
+			name:     "date in the past counts as age",
+			response: map[string]string{"Date": now.Add(-30 * time.Second).Format(http.TimeFormat), "Cache-Control": "max-age=60"},
+			wantTTL:  30 * time.Second,
+			wantAge:  30 * time.Second,
+			wantOK:   true,
+		},
+		{
+			name:     "invalid max-age is stale",
+			response: map[string]string{"Date": date, "Cache-Control": "max-age=soon"},
+			wantOK:   true,
+		},
+		{
+			name:     "expires",
+			response: map[string]string{"Date": date, "Expires": now.Add(2 * time.Minute).Format(http.TimeFormat)},
+			wantTTL:  2 * time.Minute,
+			wantOK:   true,
+		},
+		{
+			name:     "max-age wins over expires",
+			response: map[string]string{"Date": date, "Cache-Control": "max-age=60", "Expires": now.Add(time.Hour).Format(http.TimeFormat)},
+			wantTTL:  time.Minute,
+			wantOK:   true,
+		},
+		{
+			name:     "invalid expires is stale",
+			response: map[string]string{"Date": date, "Expires": "0"},
+			wantOK:   true,
+		},
+		{
+			name:     "last-modified heuristic",
+			response: map[string]string{"Date": date, "Last-Modified": now.Add(-10 * time.Hour).Format(http.TimeFormat)},
+			wantTTL:  time.Hour,
+			wantOK:   true,
+		},
+		{
+			name:     "default ttl wins over last-modified",
+			response: map[string]string{"Date": date, "Last-Modified": now.Add(-10 * time.Hour).Format(http.TimeFormat)},
+			policy:   config.CachePolicy{DefaultTTL: 30 * time.Second},
+			wantTTL:  30 * time.Second,
+			wantOK:   true,
+		},
+		{
+			name:     "no freshness information",
+			response: map[string]string{"Date": date},
+			wantTTL:  config.DefaultCacheTTL,
+			wantOK:   true,
+		},
+		{
+			name:     "max ttl caps the backend",
+			response: map[string]string{"Date": date, "Cache-Control": "max-age=3600"},
+			policy:   config.CachePolicy{MaxTTL: 10 * time.Minute},
+			wantTTL:  10 * time.Minute,
+			wantOK:   true,
+		},
+		{
+			name:     "no-cache is stored stale",
+			response: map[string]string{"Date": date, "Cache-Control": "no-cache"},
+			wantOK:   true,
+		},
+		{
+			name:     "no-store",
+			response: map[string]string{"Cache-Control": "no-store, max-age=60"},
+		},
+		{
+			name:     "private",
+			response: map[string]string{"Cache-Control": "private, max-age=60"},
+		},
+		{
+			name:     "request no-store",
+			request:  map[string]string{"Cache-Control": "no-store"},
+			response: map[string]string{"Cache-Control": "max-age=60"},
+		},
+		{
+			name:     "set-cookie",
+			response: map[string]string{"Cache-Control": "max-age=60", "Set-Cookie": "session=1"},
+		},
+		{
+			name:     "authorization",
+			request:  map[string]string{"Authorization": "Bearer token"},
+			response: map[string]string{"Cache-Control": "max-age=60"},
+		},
+		{
+			name:     "authorization with s-maxage",
+			request:  map[string]string{"Authorization": "Bearer token"},
+			response: map[string]string{"Date": date, "Cache-Control": "s-maxage=60"},
+			wantTTL:  time.Minute,
+			wantOK:   true,
+		},
+		{
+			name:     "partial content",
+			status:   fiber.StatusPartialContent,
+			response: map[string]string{"Cache-Control": "max-age=60"},
+		},
+		{
+			name:     "404 is heuristically cacheable",
+			status:   fiber.StatusNotFound,
+			response: map[string]string{"Date": date},
+			wantTTL:  config.DefaultCacheTTL,
+			wantOK:   true,
+		},
+		{
+			name:     "500 needs explicit freshness",
+			status:   fiber.StatusInternalServerError,
+			response: map[string]string{"Date": date},
+		},
+		{
+			name:     "rule ttl wins over the backend",
+			response: map[string]string{"Date": date, "Cache-Control": "max-age=60"},
+			rule:     &config.CacheRule{TTL: 5 * time.Minute},
+			wantTTL:  5 * time.Minute,
+			wantOK:   true,
+		},
+		{
+			name:     "rule status ttl",
+			status:   fiber.StatusNotFound,
+			response: map[string]string{"Date": date, "Cache-Control": "max-age=60"},
+			rule:     &config.CacheRule{TTL: 5 * time.Minute, StatusTTL: map[string]time.Duration{"404": time.Minute * 2}},
+			wantTTL:  2 * time.Minute,
+			wantOK:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCtx(t, "app.dev.space", "/")
+			for k, v := range tt.request {
+				c.Request().Header.Set(k, v)
+			}
+			setUpstreamResponse(t, c, tt.status, tt.response)
+
+			ttl, age, ok := responseFreshness(c, tt.policy, tt.rule, now)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if ttl != tt.wantTTL || age != tt.wantAge {
+				t.Errorf("ttl, age = %v, %v, want %v, %v", ttl, age, tt.wantTTL, tt.wantAge)
+			}
+		})
+	}
+}
+
+func TestParseCacheControl(t *testing.T) {
+	tests := []struct {
+		value string
+		want  cacheControl
+	}{
+		{"", cacheControl{}},
+		{"max-age=60", cacheControl{"max-age": "60"}},
+		{`Public, MAX-AGE="60" , no-cache`, cacheControl{"public": "", "max-age": "60", "no-cache": ""}},
+		{"private=\"Set-Cookie\",,", cacheControl{"private": "Set-Cookie"}},
+	}
+
+	for _, tt := range tests {
+		got := parseCacheControl(tt.value)
+		if len(got) != len(tt.want) {
+			t.Errorf("parseCacheControl(%q) = %v, want %v", tt.value, got, tt.want)
+			continue
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("parseCacheControl(%q)[%q] = %q, want %q", tt.value, k, got[k], v)
+			}
+		}
+	}
+}
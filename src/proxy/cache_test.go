@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"mixproxy/src/proxy/config"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResponseVary(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		vary     []string
+		want     []string
+		wantOK   bool
+		compress bool
+	}{
+		{
+			name:   "no vary",
+			want:   []string{},
+			wantOK: true,
+		},
+		{
+			name:   "canonical, sorted and without duplicates",
+			vary:   []string{"origin, accept-language", "Accept-Language,,X-Theme"},
+			want:   []string{"Accept-Language", "Origin", "X-Theme"},
+			wantOK: true,
+		},
+		{
+			name: "star",
+			vary: []string{"Origin, *"},
+		},
+		{
+			name:   "accept-encoding without compression",
+			vary:   []string{"Accept-Encoding, Origin"},
+			want:   []string{"Accept-Encoding", "Origin"},
+			wantOK: true,
+		},
+		{
+			name:     "accept-encoding with compression",
+			vary:     []string{"Accept-Encoding, Origin"},
+			want:     []string{"Origin"},
+			wantOK:   true,
+			compress: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := map[string]config.LoadBalancerEntry{"app": {Subdomain: "app"}}
+			if tt.compress {
+				entries["app"] = config.LoadBalancerEntry{Subdomain: "app", Compression: &config.CompressionEntry{}}
+			}
+			setupRoutes(entries)
+			t.Cleanup(func() { setupRoutes(nil) })
+
+			c := newTestCtx(t, "app.dev.space", "/")
+			for _, v := range tt.vary {
+				c.Response().Header.Add("Vary", v)
+			}
+
+			got, ok := responseVary(c)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("vary = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeVaryValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"Accept-Language", "  es-ES,   en;q=0.8 ", "es-ES, en;q=0.8"},
+		{"Accept-Encoding", "gzip, br", "br,gzip"},
+		{"Accept-Encoding", "BR ,gzip;q=1.0", "br,gzip"},
+		{"Accept-Encoding", "gzip, br;q=0, deflate;q=0.000", "gzip"},
+		{"Accept-Encoding", "", ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeVaryValue(tt.name, tt.value); got != tt.want {
+			t.Errorf("normalizeVaryValue(%q, %q) = %q, want %q", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestVariantCacheKey(t *testing.T) {
+	vary := []string{"Accept-Encoding", "Accept-Language"}
+
+	variant := func(headers map[string]string) string {
+		c := newTestCtx(t, "app.dev.space", "/")
+		for k, v := range headers {
+			c.Request().Header.Set(k, v)
+		}
+		return variantCacheKey(c, "cache:app:GET:/", vary)
+	}
+
+	tests := []struct {
+		name string
+		a, b map[string]string
+		same bool
+	}{
+		{
+			name: "equivalent values",
+			a:    map[string]string{"Accept-Encoding": "gzip, br", "Accept-Language": "es"},
+			b:    map[string]string{"Accept-Encoding": "br,gzip;q=1", "Accept-Language": " es "},
+			same: true,
+		},
+		{
+			name: "headers outside vary",
+			a:    map[string]string{"Accept-Language": "es", "Cookie": "a=1"},
+			b:    map[string]string{"Accept-Language": "es", "Cookie": "a=2"},
+			same: true,
+		},
+		{
+			name: "different language",
+			a:    map[string]string{"Accept-Language": "es"},
+			b:    map[string]string{"Accept-Language": "en"},
+		},
+		{
+			name: "missing header",
+			a:    map[string]string{"Accept-Language": "es"},
+			b:    map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := variant(tt.a), variant(tt.b)
+			if !strings.HasPrefix(a, "cache:app:GET:/:vary:") {
+				t.Errorf("key = %q", a)
+			}
+			if (a == b) != tt.same {
+				t.Errorf("keys %q and %q, want same = %v", a, b, tt.same)
+			}
+		})
+	}
+}
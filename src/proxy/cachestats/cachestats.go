@@ -0,0 +1,135 @@
+package cachestats
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Result is how the cache took part in a response; it is sent to the client
+// in the X-Cache header.
+type Result string
+
+const (
+	Hit         Result = "HIT"
+	Miss        Result = "MISS"
+	Stale       Result = "STALE"
+	Revalidated Result = "REVALIDATED"
+	Bypass      Result = "BYPASS"
+)
+
+type counters struct {
+	hits        atomic.Int64
+	misses      atomic.Int64
+	stale       atomic.Int64
+	revalidated atomic.Int64
+	bypasses    atomic.Int64
+	stores      atomic.Int64
+	storedBytes atomic.Int64
+}
+
+// Stats is the JSON view of the counters of a subdomain since the proxy
+// started. StoredBytes adds up every response written to the cache.
+type Stats struct {
+	Subdomain   string  `json:"subdomain"`
+	Hits        int64   `json:"hits"`
+	Misses      int64   `json:"misses"`
+	Stale       int64   `json:"stale"`
+	Revalidated int64   `json:"revalidated"`
+	Bypasses    int64   `json:"bypasses"`
+	Stores      int64   `json:"stores"`
+	StoredBytes int64   `json:"stored_bytes"`
+	HitRatio    float64 `json:"hit_ratio"`
+}
+
+var (
+	all   = map[string]*counters{}
+	allMu sync.RWMutex
+)
+
+func get(subdomain string) *counters {
+	allMu.RLock()
+	c, ok := all[subdomain]
+	allMu.RUnlock()
+	if ok {
+		return c
+	}
+
+	allMu.Lock()
+	defer allMu.Unlock()
+
+	if c, ok = all[subdomain]; !ok {
+		c = &counters{}
+		all[subdomain] = c
+	}
+	return c
+}
+
+func Record(subdomain string, result Result) {
+	c := get(subdomain)
+
+	switch result {
+	case Hit:
+		c.hits.Add(1)
+	case Miss:
+		c.misses.Add(1)
+	case Stale:
+		c.stale.Add(1)
+	case Revalidated:
+		c.revalidated.Add(1)
+	case Bypass:
+		c.bypasses.Add(1)
+	}
+}
+
+// RecordStore counts a response of size bytes written to the cache.
+func RecordStore(subdomain string, size int64) {
+	c := get(subdomain)
+	c.stores.Add(1)
+	c.storedBytes.Add(size)
+}
+
+func Get(subdomain string) Stats {
+	allMu.RLock()
+	c, ok := all[subdomain]
+	allMu.RUnlock()
+	if !ok {
+		return Stats{Subdomain: subdomain}
+	}
+
+	return c.stats(subdomain)
+}
+
+// List returns the stats of every subdomain with traffic, sorted by name.
+func List() []Stats {
+	allMu.RLock()
+	list := make([]Stats, 0, len(all))
+	for subdomain, c := range all {
+		list = append(list, c.stats(subdomain))
+	}
+	allMu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Subdomain < list[j].Subdomain })
+	return list
+}
+
+func (c *counters) stats(subdomain string) Stats {
+	s := Stats{
+		Subdomain:   subdomain,
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Stale:       c.stale.Load(),
+		Revalidated: c.revalidated.Load(),
+		Bypasses:    c.bypasses.Load(),
+		Stores:      c.stores.Load(),
+		StoredBytes: c.storedBytes.Load(),
+	}
+
+	// Las respuestas caducadas y las revalidadas también salen de la caché
+	served := s.Hits + s.Stale + s.Revalidated
+	if total := served + s.Misses; total > 0 {
+		s.HitRatio = float64(served) / float64(total)
+	}
+
+	return s
+}
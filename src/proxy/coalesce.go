@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// flight is an upstream fetch of a cache key that other requests for the same
+// key wait for instead of going to the backend themselves. Requests of a Vary
+// response use their variant key, so different variants don't wait for each
+// other; until the URL is first stored its variants aren't known and all of
+// them share the URL key.
+type flight struct {
+	key    string
+	done   chan struct{}
+	landed sync.Once
+}
+
+var (
+	flights   = map[string]*flight{}
+	flightsMu sync.Mutex
+)
+
+// joinFlight returns the fetch in progress for key, or starts a new one and
+// reports the caller as its leader. The leader must call land once it knows
+// whether the response is cached.
+func joinFlight(key string) (*flight, bool) {
+	flightsMu.Lock()
+	defer flightsMu.Unlock()
+
+	if f, ok := flights[key]; ok {
+		return f, false
+	}
+
+	f := &flight{key: key, done: make(chan struct{})}
+	flights[key] = f
+
+	return f, true
+}
+
+// land wakes the requests waiting for the flight. It may be called more than
+// once.
+func (f *flight) land() {
+	f.landed.Do(func() {
+		flightsMu.Lock()
+		delete(flights, f.key)
+		flightsMu.Unlock()
+
+		close(f.done)
+	})
+}
+
+// wait blocks until the leader lands or timeout passes. It returns false on
+// timeout.
+func (f *flight) wait(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-f.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// coalesceTimeout returns how long a cache miss waits for the fetch of the
+// same key already in progress, or 0 when the request must not be coalesced.
+func coalesceTimeout(c *fiber.Ctx, subdomain string) time.Duration {
+	if c.Method() != "GET" || isStreamingRequest(c, subdomain) {
+		return 0
+	}
+
+	// Quien pide no-cache quiere su propia respuesta del backend
+	if parseCacheControl(c.Get(fiber.HeaderCacheControl)).has("no-cache") {
+		return 0
+	}
+
+	// Con credenciales la respuesta suele ser privada y no se guardará
+	if len(c.Request().Header.Peek(fiber.HeaderCookie)) != 0 || len(c.Request().Header.Peek(fiber.HeaderAuthorization)) != 0 {
+		return 0
+	}
+
+	route, _ := getRoute(subdomain)
+	policy, err := route.Cache.Policy()
+	if err != nil {
+		log.Printf("Invalid cache configuration for subdomain '%s': %v", subdomain, err)
+	}
+
+	if policy.CoalesceTimeout <= 0 || !cachesPath(c) {
+		return 0
+	}
+
+	return policy.CoalesceTimeout
+}
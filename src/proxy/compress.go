@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"log"
+	"mixproxy/src/proxy/config"
+	"mixproxy/src/redis"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// getCompression returns the compression settings of the subdomain; ok is
+// false when the route doesn't compress at the proxy.
+func getCompression(subdomain string) (config.CompressionSettings, bool) {
+	route, _ := getRoute(subdomain)
+	if route.Compression == nil {
+		return config.CompressionSettings{}, false
+	}
+
+	settings, err := route.Compression.Settings()
+	if err != nil {
+		log.Printf("Invalid compression configuration for subdomain '%s': %v", subdomain, err)
+		return settings, false
+	}
+
+	return settings, true
+}
+
+// withoutUpstreamCompression removes Accept-Encoding from the request to the
+// backend when the proxy compresses, so the cache keeps one uncompressed copy
+// of each response. The returned function puts it back.
+func withoutUpstreamCompression(c *fiber.Ctx, subdomain string) func() {
+	if _, ok := getCompression(subdomain); !ok {
+		return func() {}
+	}
+
+	acceptEncoding := string(c.Request().Header.Peek(fiber.HeaderAcceptEncoding))
+	if acceptEncoding == "" {
+		return func() {}
+	}
+
+	c.Request().Header.Del(fiber.HeaderAcceptEncoding)
+	return func() {
+		c.Request().Header.Set(fiber.HeaderAcceptEncoding, acceptEncoding)
+	}
+}
+
+// negotiateEncoding picks the first of encodings the client accepts with the
+// highest q-value, "" when none is acceptable.
+func negotiateEncoding(acceptEncoding string, encodings []string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		qualities[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range encodings {
+		q, ok := qualities[encoding]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	return best
+}
+
+// compressible reports whether the response may be compressed judging by its
+// headers: a GET or HEAD answer of a configured type, not encoded yet and
+// without no-transform. The size is checked by the caller.
+func compressible(c *fiber.Ctx, settings config.CompressionSettings, header *fasthttp.ResponseHeader) bool {
+	if c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead {
+		return false
+	}
+
+	status := header.StatusCode()
+	if status == fiber.StatusNoContent || status == fiber.StatusPartialContent || status == fiber.StatusNotModified {
+		return false
+	}
+	if len(header.Peek(fiber.HeaderContentEncoding)) != 0 {
+		return false
+	}
+	if parseCacheControl(string(header.Peek(fiber.HeaderCacheControl))).has("no-transform") {
+		return false
+	}
+
+	return settings.Compresses(string(header.ContentType()))
+}
+
+func compressBody(encoding string, body []byte) []byte {
+	switch encoding {
+	case "br":
+		return fasthttp.AppendBrotliBytesLevel(nil, body, fasthttp.CompressBrotliDefaultCompression)
+	case "gzip":
+		return fasthttp.AppendGzipBytesLevel(nil, body, fasthttp.CompressDefaultCompression)
+	}
+	return body
+}
+
+// setEncoding sends body compressed with encoding. The ETag becomes weak
+// because the bytes differ from the uncompressed response.
+func setEncoding(c *fiber.Ctx, encoding string, body []byte) {
+	if etag := string(c.Response().Header.Peek(fiber.HeaderETag)); etag != "" && !strings.HasPrefix(etag, "W/") {
+		c.Set(fiber.HeaderETag, "W/"+etag)
+	}
+	c.Set(fiber.HeaderContentEncoding, encoding)
+	c.Response().SetBody(body)
+}
+
+// setHeadEncoding answers a HEAD request with the headers a GET would get
+// compressed with encoding, whose length isn't known.
+func setHeadEncoding(c *fiber.Ctx, encoding string) {
+	if etag := string(c.Response().Header.Peek(fiber.HeaderETag)); etag != "" && !strings.HasPrefix(etag, "W/") {
+		c.Set(fiber.HeaderETag, "W/"+etag)
+	}
+	c.Set(fiber.HeaderContentEncoding, encoding)
+	c.Response().Header.SetContentLength(-1)
+}
+
+// addVaryAcceptEncoding tells downstream caches the response depends on the
+// client's Accept-Encoding.
+func addVaryAcceptEncoding(c *fiber.Ctx) {
+	for _, value := range c.Response().Header.PeekAll(fiber.HeaderVary) {
+		for _, name := range strings.Split(string(value), ",") {
+			if strings.EqualFold(strings.TrimSpace(name), fiber.HeaderAcceptEncoding) {
+				return
+			}
+		}
+	}
+
+	c.Response().Header.Add(fiber.HeaderVary, fiber.HeaderAcceptEncoding)
+}
+
+// compressResponse compresses the upstream response for the client. The body
+// is only read once the headers allow compressing it and its Content-Length
+// is within the configured sizes. When the response was just stored, the
+// compressed body is stored beside it so cache hits don't compress it again.
+func compressResponse(c *fiber.Ctx, subdomain string, stored *redis.CachedResponse) {
+	settings, ok := getCompression(subdomain)
+	if !ok || !compressible(c, settings, &c.Response().Header) {
+		return
+	}
+
+	// Sin longitud conocida la respuesta sigue en streaming sin comprimir
+	length := c.Response().Header.ContentLength()
+	if length < settings.MinSize || length > settings.MaxSize {
+		return
+	}
+
+	addVaryAcceptEncoding(c)
+
+	encoding := negotiateEncoding(c.Get(fiber.HeaderAcceptEncoding), settings.Encodings)
+	if encoding == "" {
+		return
+	}
+
+	if c.Method() == fiber.MethodHead {
+		// Sin cuerpo no se sabe cuánto ocupará comprimido, se anuncia sin longitud
+		setHeadEncoding(c, encoding)
+		return
+	}
+
+	body, ok := readUpstreamBody(c, int64(settings.MaxSize))
+	if !ok {
+		return
+	}
+
+	body = compressBody(encoding, body)
+	if stored != nil {
+		storeEncodedBody(*stored, encoding, body)
+	}
+
+	setEncoding(c, encoding, body)
+}
+
+// serveEncoded sends a cached response compressed when the client accepts it,
+// compressing and storing the body only the first time. HEAD requests get the
+// headers of the compressed GET response. It reports whether the body was
+// sent.
+func serveEncoded(c *fiber.Ctx, cached redis.CachedResponse) bool {
+	settings, ok := getCompression(getSubdomain(c))
+	if !ok || !compressible(c, settings, &c.Response().Header) {
+		return false
+	}
+	if len(cached.Body) < settings.MinSize || len(cached.Body) > settings.MaxSize {
+		return false
+	}
+
+	addVaryAcceptEncoding(c)
+
+	encoding := negotiateEncoding(c.Get(fiber.HeaderAcceptEncoding), settings.Encodings)
+	if encoding == "" {
+		return false
+	}
+
+	body, found := loadEncodedBody(cached, encoding)
+	if !found {
+		body = compressBody(encoding, cached.Body)
+		storeEncodedBody(cached, encoding, body)
+	}
+
+	setEncoding(c, encoding, body)
+	return true
+}
+
+// The compressed body is only valid for the response it was made from, which
+// is told by its Created and Expires: a refreshed or revalidated response
+// gets its body compressed again.
+func loadEncodedBody(cached redis.CachedResponse, encoding string) ([]byte, bool) {
+	if cached.Key == "" {
+		return nil, false
+	}
+
+	encoded, found, err := redis.GetCachedResponse(redis.EncodedCacheKey(cached.Key, encoding))
+	if err != nil {
+		log.Printf("Redis error: %v", err)
+		return nil, false
+	}
+	if !found || !encoded.Created.Equal(cached.Created) || !encoded.Expires.Equal(cached.Expires) {
+		return nil, false
+	}
+
+	return encoded.Body, true
+}
+
+func storeEncodedBody(cached redis.CachedResponse, encoding string, body []byte) {
+	if cached.Key == "" {
+		return
+	}
+
+	ttl := redis.CacheTTL(cached.Key)
+	if ttl <= 0 {
+		return
+	}
+
+	encoded := redis.CachedResponse{Status: cached.Status, Body: body, Created: cached.Created, Expires: cached.Expires}
+	if err := redis.SetCachedResponse(redis.EncodedCacheKey(cached.Key, encoding), encoded, ttl, false); err != nil {
+		log.Printf("Failed to cache compressed response: %v", err)
+	}
+}
@@ -0,0 +1,367 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheEntry tunes the cache of a subdomain. Durations use time.ParseDuration
+// syntax ("30s", "5m").
+type CacheEntry struct {
+	// DefaultTTL is used when the backend gives no max-age, s-maxage or
+	// Expires; MaxTTL caps whatever the backend asks for.
+	DefaultTTL string `json:"default_ttl,omitempty"`
+	MaxTTL     string `json:"max_ttl,omitempty"`
+	// Keep is how long stale responses with an ETag or Last-Modified stay
+	// around to be revalidated with a conditional request.
+	Keep string `json:"keep,omitempty"`
+	// AllowPurge accepts "PURGE /path" requests from IPs on the subdomain
+	// whitelist.
+	AllowPurge bool `json:"allow_purge,omitempty"`
+
+	// Grace periods after expiry during which the response is served while it
+	// is refreshed in the background, or when the backends fail; the
+	// response's own directives take precedence.
+	StaleWhileRevalidate string `json:"stale_while_revalidate,omitempty"`
+	StaleIfError         string `json:"stale_if_error,omitempty"`
+	// CoalesceTimeout is how long concurrent misses of a URL wait for the
+	// single request sent to the backend before going there themselves;
+	// coalescing is off unless it is set.
+	CoalesceTimeout string `json:"coalesce_timeout,omitempty"`
+
+	// MaxObjectSize (bytes) keeps big responses out of the cache.
+	MaxObjectSize int64 `json:"max_object_size,omitempty"`
+	// CompressStorage deflates the bodies stored in Redis.
+	CompressStorage bool `json:"compress_storage,omitempty"`
+
+	// Rules refine the cache per path; the first matching rule applies.
+	Rules []CacheRuleEntry `json:"rules,omitempty"`
+	// Warm fills the cache after a reload or on demand.
+	Warm *CacheWarmEntry `json:"warm,omitempty"`
+}
+
+type CachePolicy struct {
+	DefaultTTL time.Duration
+	MaxTTL     time.Duration
+	Keep       time.Duration
+
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	CoalesceTimeout      time.Duration
+
+	MaxObjectSize   int64
+	CompressStorage bool
+}
+
+// DefaultCacheTTL is how long a response without freshness information is
+// cached when the route doesn't set default_ttl and it has no Last-Modified.
+const DefaultCacheTTL = 15 * time.Minute
+
+// DefaultCacheKeep is used when the route doesn't set keep.
+const DefaultCacheKeep = time.Hour
+
+// DefaultCacheMaxObjectSize is used when the route doesn't set max_object_size.
+const DefaultCacheMaxObjectSize = 10 << 20
+
+func (e *CacheEntry) Policy() (CachePolicy, error) {
+	policy := CachePolicy{
+		Keep:          DefaultCacheKeep,
+		MaxObjectSize: DefaultCacheMaxObjectSize,
+	}
+	if e == nil {
+		return policy, nil
+	}
+
+	if e.MaxObjectSize < 0 {
+		return policy, fmt.Errorf("max_object_size can't be negative")
+	}
+	if e.MaxObjectSize > 0 {
+		policy.MaxObjectSize = e.MaxObjectSize
+	}
+	policy.CompressStorage = e.CompressStorage
+
+	if err := e.Warm.validate(); err != nil {
+		return policy, err
+	}
+
+	durations := []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"default_ttl", e.DefaultTTL, &policy.DefaultTTL},
+		{"max_ttl", e.MaxTTL, &policy.MaxTTL},
+		{"keep", e.Keep, &policy.Keep},
+		{"stale_while_revalidate", e.StaleWhileRevalidate, &policy.StaleWhileRevalidate},
+		{"stale_if_error", e.StaleIfError, &policy.StaleIfError},
+		{"coalesce_timeout", e.CoalesceTimeout, &policy.CoalesceTimeout},
+	}
+
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return policy, fmt.Errorf("invalid %s '%s': %w", d.name, d.value, err)
+		}
+		if parsed < 0 {
+			return policy, fmt.Errorf("%s can't be negative", d.name)
+		}
+		*d.dest = parsed
+	}
+
+	return policy, nil
+}
+
+// CacheRuleEntry applies to the paths matching Path, a "/*"-style pattern or
+// a glob ("/img/*.png"), or Regex. Paths matching a rule are cached even if
+// they aren't in cache_paths, unless the rule sets Bypass. TTL overrides the
+// freshness given by the backend and StatusTTL does it for some status codes
+// ("404" or "4xx"), which also makes error responses cacheable. QueryParams
+// keeps only those query parameters in the cache key and IgnoreQueryParams
+// drops them ("*" drops the whole query string).
+type CacheRuleEntry struct {
+	Path  string `json:"path,omitempty"`
+	Regex string `json:"regex,omitempty"`
+
+	TTL       string            `json:"ttl,omitempty"`
+	StatusTTL map[string]string `json:"status_ttl,omitempty"`
+
+	QueryParams       []string `json:"query_params,omitempty"`
+	IgnoreQueryParams []string `json:"ignore_query_params,omitempty"`
+
+	Bypass bool `json:"bypass,omitempty"`
+}
+
+type CacheRule struct {
+	Path  string
+	Regex *regexp.Regexp
+
+	TTL       time.Duration
+	StatusTTL map[string]time.Duration
+
+	QueryParams       []string
+	IgnoreQueryParams []string
+
+	Bypass bool
+}
+
+// CacheRules compiles the rules of the route in order.
+func (e *CacheEntry) CacheRules() ([]CacheRule, error) {
+	if e == nil {
+		return nil, nil
+	}
+
+	rules := make([]CacheRule, 0, len(e.Rules))
+	for i, entry := range e.Rules {
+		rule, err := entry.compile()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (e CacheRuleEntry) compile() (CacheRule, error) {
+	rule := CacheRule{
+		Path:              e.Path,
+		QueryParams:       e.QueryParams,
+		IgnoreQueryParams: e.IgnoreQueryParams,
+		Bypass:            e.Bypass,
+	}
+
+	if (e.Path == "") == (e.Regex == "") {
+		return rule, fmt.Errorf("set either path or regex")
+	}
+	if e.Path != "" {
+		if !strings.HasPrefix(e.Path, "/") {
+			return rule, fmt.Errorf("path '%s' must start with '/'", e.Path)
+		}
+		if _, err := path.Match(e.Path, "/"); err != nil {
+			return rule, fmt.Errorf("invalid path '%s': %w", e.Path, err)
+		}
+	}
+	if e.Regex != "" {
+		re, err := regexp.Compile(e.Regex)
+		if err != nil {
+			return rule, fmt.Errorf("invalid regex '%s': %w", e.Regex, err)
+		}
+		rule.Regex = re
+	}
+	if len(e.QueryParams) != 0 && len(e.IgnoreQueryParams) != 0 {
+		return rule, fmt.Errorf("query_params and ignore_query_params can't be used together")
+	}
+
+	if e.TTL != "" {
+		ttl, err := time.ParseDuration(e.TTL)
+		if err != nil {
+			return rule, fmt.Errorf("invalid ttl '%s': %w", e.TTL, err)
+		}
+		if ttl < 0 {
+			return rule, fmt.Errorf("ttl can't be negative")
+		}
+		rule.TTL = ttl
+	}
+
+	if len(e.StatusTTL) != 0 {
+		rule.StatusTTL = map[string]time.Duration{}
+	}
+	for status, value := range e.StatusTTL {
+		if !validStatusPattern(status) {
+			return rule, fmt.Errorf("invalid status '%s' in status_ttl, use a code like 404 or a class like 4xx", status)
+		}
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			return rule, fmt.Errorf("invalid status_ttl '%s' for %s: %w", value, status, err)
+		}
+		if ttl < 0 {
+			return rule, fmt.Errorf("status_ttl for %s can't be negative", status)
+		}
+		rule.StatusTTL[strings.ToLower(status)] = ttl
+	}
+
+	return rule, nil
+}
+
+func validStatusPattern(status string) bool {
+	if len(status) != 3 || status[0] < '1' || status[0] > '5' {
+		return false
+	}
+	if strings.EqualFold(status[1:], "xx") {
+		return true
+	}
+	_, err := strconv.Atoi(status)
+	return err == nil
+}
+
+// Matches reports whether the rule applies to a request path (without query).
+func (r *CacheRule) Matches(p string) bool {
+	if r.Regex != nil {
+		return r.Regex.MatchString(p)
+	}
+
+	if r.Path == "/*" {
+		return true
+	}
+	if strings.HasSuffix(r.Path, "/*") && !strings.ContainsAny(strings.TrimSuffix(r.Path, "/*"), "*?[") {
+		return strings.HasPrefix(p, strings.TrimSuffix(r.Path, "/*"))
+	}
+
+	matched, _ := path.Match(r.Path, p)
+	return matched
+}
+
+// ResponseTTL returns the TTL the rule forces for a response status: the
+// status_ttl of the code, then of its class, then ttl. Error responses are
+// only cached through status_ttl.
+func (r *CacheRule) ResponseTTL(status int) (time.Duration, bool) {
+	code := strconv.Itoa(status)
+	if ttl, ok := r.StatusTTL[code]; ok {
+		return ttl, true
+	}
+	if ttl, ok := r.StatusTTL[code[:1]+"xx"]; ok {
+		return ttl, true
+	}
+	if r.TTL > 0 && status < 400 {
+		return r.TTL, true
+	}
+
+	return 0, false
+}
+
+// CacheWarmEntry lists what to request to fill the cache of a subdomain:
+// URLs (paths such as "/" or "/blog/") and the URLs of Sitemap, a path or a
+// full URL of a sitemap.xml or sitemap index. Concurrency bounds the requests
+// in flight. OnReload warms the cache after every reload.
+type CacheWarmEntry struct {
+	URLs        []string `json:"urls,omitempty"`
+	Sitemap     string   `json:"sitemap,omitempty"`
+	Concurrency int      `json:"concurrency,omitempty"`
+	OnReload    bool     `json:"on_reload,omitempty"`
+}
+
+// ValidateWarmURLs checks that urls are paths: a full URL would send the
+// request to another host.
+func ValidateWarmURLs(urls []string) error {
+	for _, u := range urls {
+		if !strings.HasPrefix(u, "/") {
+			return fmt.Errorf("warm url '%s' must start with '/'", u)
+		}
+	}
+
+	return nil
+}
+
+// DefaultWarmConcurrency is used when warm doesn't set concurrency.
+const DefaultWarmConcurrency = 4
+
+func (e *CacheWarmEntry) validate() error {
+	if e == nil {
+		return nil
+	}
+
+	if len(e.URLs) == 0 && e.Sitemap == "" {
+		return fmt.Errorf("warm needs urls or a sitemap")
+	}
+	if err := ValidateWarmURLs(e.URLs); err != nil {
+		return err
+	}
+	if e.Concurrency < 0 {
+		return fmt.Errorf("warm concurrency can't be negative")
+	}
+
+	return nil
+}
+
+// WarmConcurrency returns how many warming requests may run at once.
+func (e *CacheWarmEntry) WarmConcurrency() int {
+	if e == nil || e.Concurrency == 0 {
+		return DefaultWarmConcurrency
+	}
+	return e.Concurrency
+}
+
+// L1CacheEntry keeps the most used cached responses in the memory of each
+// proxy, in front of Redis. MaxSize and MaxEntrySize are in bytes; responses
+// bigger than MaxEntrySize are only kept in Redis.
+type L1CacheEntry struct {
+	MaxSize      int64 `json:"max_size"`
+	MaxEntrySize int64 `json:"max_entry_size,omitempty"`
+}
+
+// DefaultL1MaxEntrySize is used when l1_cache doesn't set max_entry_size.
+const DefaultL1MaxEntrySize = 1 << 20
+
+// Sizes returns the size limits of the L1 cache, 0 when it is disabled.
+func (e *L1CacheEntry) Sizes() (maxSize, maxEntrySize int64) {
+	if e == nil {
+		return 0, 0
+	}
+
+	maxEntrySize = e.MaxEntrySize
+	if maxEntrySize == 0 {
+		maxEntrySize = DefaultL1MaxEntrySize
+	}
+
+	return e.MaxSize, maxEntrySize
+}
+
+func (e *L1CacheEntry) validate() error {
+	if e == nil {
+		return nil
+	}
+
+	if e.MaxSize < 0 || e.MaxEntrySize < 0 {
+		return fmt.Errorf("sizes can't be negative")
+	}
+
+	return nil
+}
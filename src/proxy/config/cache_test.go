@@ -0,0 +1,165 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCachePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   *CacheEntry
+		want    CachePolicy
+		wantErr bool
+	}{
+		{
+			name:  "defaults",
+			entry: nil,
+			want:  CachePolicy{Keep: DefaultCacheKeep, MaxObjectSize: DefaultCacheMaxObjectSize},
+		},
+		{
+			name: "durations and storage",
+			entry: &CacheEntry{
+				DefaultTTL:           "30s",
+				MaxTTL:               "1h",
+				Keep:                 "0s",
+				StaleWhileRevalidate: "1m",
+				StaleIfError:         "10m",
+				CoalesceTimeout:      "5s",
+				MaxObjectSize:        1024,
+				CompressStorage:      true,
+			},
+			want: CachePolicy{
+				DefaultTTL:           30 * time.Second,
+				MaxTTL:               time.Hour,
+				StaleWhileRevalidate: time.Minute,
+				StaleIfError:         10 * time.Minute,
+				CoalesceTimeout:      5 * time.Second,
+				MaxObjectSize:        1024,
+				CompressStorage:      true,
+			},
+		},
+		{name: "invalid duration", entry: &CacheEntry{DefaultTTL: "soon"}, wantErr: true},
+		{name: "negative duration", entry: &CacheEntry{MaxTTL: "-1m"}, wantErr: true},
+		{name: "negative max_object_size", entry: &CacheEntry{MaxObjectSize: -1}, wantErr: true},
+		{name: "warm without urls", entry: &CacheEntry{Warm: &CacheWarmEntry{}}, wantErr: true},
+		{name: "warm with a full url", entry: &CacheEntry{Warm: &CacheWarmEntry{URLs: []string{"http://other/"}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.entry.Policy()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("policy = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    CacheRuleEntry
+		wantErr bool
+	}{
+		{name: "path", rule: CacheRuleEntry{Path: "/img/*.png", TTL: "1h"}},
+		{name: "regex", rule: CacheRuleEntry{Regex: `^/api/v\d+/`, StatusTTL: map[string]string{"404": "1m", "5XX": "10s"}}},
+		{name: "neither path nor regex", rule: CacheRuleEntry{TTL: "1h"}, wantErr: true},
+		{name: "both path and regex", rule: CacheRuleEntry{Path: "/a", Regex: "a"}, wantErr: true},
+		{name: "relative path", rule: CacheRuleEntry{Path: "img/*"}, wantErr: true},
+		{name: "invalid glob", rule: CacheRuleEntry{Path: "/[a"}, wantErr: true},
+		{name: "invalid regex", rule: CacheRuleEntry{Regex: "("}, wantErr: true},
+		{name: "query_params with ignore_query_params", rule: CacheRuleEntry{Path: "/*", QueryParams: []string{"a"}, IgnoreQueryParams: []string{"b"}}, wantErr: true},
+		{name: "invalid ttl", rule: CacheRuleEntry{Path: "/*", TTL: "1 hour"}, wantErr: true},
+		{name: "negative ttl", rule: CacheRuleEntry{Path: "/*", TTL: "-1s"}, wantErr: true},
+		{name: "invalid status", rule: CacheRuleEntry{Path: "/*", StatusTTL: map[string]string{"600": "1m"}}, wantErr: true},
+		{name: "invalid status class", rule: CacheRuleEntry{Path: "/*", StatusTTL: map[string]string{"4x": "1m"}}, wantErr: true},
+		{name: "invalid status ttl", rule: CacheRuleEntry{Path: "/*", StatusTTL: map[string]string{"404": "soon"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &CacheEntry{Rules: []CacheRuleEntry{tt.rule}}
+			rules, err := entry.CacheRules()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(rules) != 1 {
+				t.Errorf("got %d rules", len(rules))
+			}
+		})
+	}
+}
+
+func TestCacheRuleMatches(t *testing.T) {
+	tests := []struct {
+		rule CacheRuleEntry
+		path string
+		want bool
+	}{
+		{CacheRuleEntry{Path: "/*"}, "/anything/at/all", true},
+		{CacheRuleEntry{Path: "/blog/*"}, "/blog/post", true},
+		{CacheRuleEntry{Path: "/blog/*"}, "/blog/2025/post", true},
+		{CacheRuleEntry{Path: "/blog/*"}, "/news", false},
+		{CacheRuleEntry{Path: "/img/*.png"}, "/img/logo.png", true},
+		{CacheRuleEntry{Path: "/img/*.png"}, "/img/logo.jpg", false},
+		{CacheRuleEntry{Path: "/img/*.png"}, "/img/icons/logo.png", false},
+		{CacheRuleEntry{Path: "/about"}, "/about", true},
+		{CacheRuleEntry{Path: "/about"}, "/about/team", false},
+		{CacheRuleEntry{Regex: `^/api/v\d+/`}, "/api/v2/users", true},
+		{CacheRuleEntry{Regex: `^/api/v\d+/`}, "/api/latest/users", false},
+	}
+
+	for _, tt := range tests {
+		rule, err := tt.rule.compile()
+		if err != nil {
+			t.Fatalf("compile %+v: %v", tt.rule, err)
+		}
+		if got := rule.Matches(tt.path); got != tt.want {
+			t.Errorf("rule %+v matches %q = %v, want %v", tt.rule, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCacheRuleResponseTTL(t *testing.T) {
+	rule, err := CacheRuleEntry{
+		Path:      "/*",
+		TTL:       "1h",
+		StatusTTL: map[string]string{"404": "1m", "4XX": "10s", "301": "24h"},
+	}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	noTTL, err := CacheRuleEntry{Path: "/*", StatusTTL: map[string]string{"5xx": "5s"}}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		rule   CacheRule
+		status int
+		want   time.Duration
+		wantOK bool
+	}{
+		{"ttl", rule, 200, time.Hour, true},
+		{"status code wins over ttl", rule, 301, 24 * time.Hour, true},
+		{"status code wins over class", rule, 404, time.Minute, true},
+		{"status class", rule, 410, 10 * time.Second, true},
+		{"errors need status_ttl", rule, 500, 0, false},
+		{"without ttl", noTTL, 200, 0, false},
+		{"class without ttl", noTTL, 503, 5 * time.Second, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.rule.ResponseTTL(tt.status)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("ResponseTTL(%d) = %v, %v, want %v, %v", tt.status, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
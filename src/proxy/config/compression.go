@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CompressionEntry compresses the responses of a subdomain at the proxy with
+// the first of Encodings the client accepts. Only responses of Types ("text/*"
+// matches every text type) with a Content-Length between MinSize and MaxSize
+// bytes are compressed; the rest keep streaming.
+type CompressionEntry struct {
+	Types     []string `json:"types,omitempty"`
+	MinSize   int      `json:"min_size,omitempty"`
+	MaxSize   int      `json:"max_size,omitempty"`
+	Encodings []string `json:"encodings,omitempty"`
+}
+
+type CompressionSettings struct {
+	Types     []string
+	MinSize   int
+	MaxSize   int
+	Encodings []string
+}
+
+// DefaultCompressionTypes are compressed when the route doesn't set types.
+var DefaultCompressionTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// DefaultCompressionMinSize is used when the route doesn't set min_size;
+// smaller bodies barely shrink.
+const DefaultCompressionMinSize = 1024
+
+// DefaultCompressionMaxSize is used when the route doesn't set max_size.
+const DefaultCompressionMaxSize = 10 << 20
+
+var supportedEncodings = map[string]bool{"br": true, "gzip": true}
+
+func (e *CompressionEntry) Settings() (CompressionSettings, error) {
+	settings := CompressionSettings{
+		Types:     DefaultCompressionTypes,
+		MinSize:   DefaultCompressionMinSize,
+		MaxSize:   DefaultCompressionMaxSize,
+		Encodings: []string{"br", "gzip"},
+	}
+	if e == nil {
+		return settings, nil
+	}
+
+	if e.MinSize < 0 || e.MaxSize < 0 {
+		return settings, fmt.Errorf("min_size and max_size can't be negative")
+	}
+	if e.MinSize > 0 {
+		settings.MinSize = e.MinSize
+	}
+	if e.MaxSize > 0 {
+		settings.MaxSize = e.MaxSize
+	}
+	if settings.MinSize > settings.MaxSize {
+		return settings, fmt.Errorf("min_size can't be bigger than max_size")
+	}
+
+	if len(e.Types) != 0 {
+		settings.Types = []string{}
+		for _, t := range e.Types {
+			settings.Types = append(settings.Types, strings.ToLower(strings.TrimSpace(t)))
+		}
+	}
+
+	if len(e.Encodings) != 0 {
+		settings.Encodings = []string{}
+		for _, encoding := range e.Encodings {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if !supportedEncodings[encoding] {
+				return settings, fmt.Errorf("unsupported encoding '%s', use br or gzip", encoding)
+			}
+			settings.Encodings = append(settings.Encodings, encoding)
+		}
+	}
+
+	return settings, nil
+}
+
+// Compresses reports whether responses of the content type are compressed.
+func (s CompressionSettings) Compresses(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+
+	for _, t := range s.Types {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == t {
+			return true
+		}
+	}
+
+	return false
+}
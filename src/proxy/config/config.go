@@ -1,11 +1,18 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -19,6 +26,25 @@ type Config struct {
 	ModeDeveloper       bool                `json:"mode_developer"`
 	LoadBalancer        []LoadBalancerEntry `json:"load_balancer"`
 	RootLoadBalancer    *LoadBalancerEntry  `json:"root_load_balancer,omitempty"`
+	Listeners           []ListenerEntry     `json:"listeners,omitempty"`
+	TLS                 *TLSEntry           `json:"tls,omitempty"`
+	L1Cache             *L1CacheEntry       `json:"l1_cache,omitempty"`
+	// CertExpiryWarningDays is how close to expiry a certificate must be to
+	// be reported by /api/certificates. Defaults to 30.
+	CertExpiryWarningDays int `json:"cert_expiry_warning_days,omitempty"`
+}
+
+// ListenerEntry is an address the proxy accepts connections on. When
+// UnixSocket is set, Address, Port and IPVersion are ignored.
+type ListenerEntry struct {
+	Address    string `json:"address,omitempty"`
+	Port       int    `json:"port,omitempty"`
+	Protocol   string `json:"protocol"`
+	IPVersion  string `json:"ip_version,omitempty"`
+	UnixSocket string `json:"unix_socket,omitempty"`
+	// RedirectToHTTPS sends plain HTTP requests to the first HTTPS listener,
+	// except for subdomains with allow_http.
+	RedirectToHTTPS bool `json:"redirect_to_https,omitempty"`
 }
 
 type LoadBalancerEntry struct {
@@ -30,62 +56,206 @@ type LoadBalancerEntry struct {
 	CachePaths        []string   `json:"cache_paths"`
 	WhitelistsEnabled bool       `json:"whitelist_enabled"`
 	BlacklistsEnabled bool       `json:"blacklist_enabled"`
+	MTLS              *MTLSEntry `json:"mtls,omitempty"`
+	// AllowHTTP serves the subdomain on plain HTTP instead of redirecting to
+	// HTTPS. It only matters when on_https is true.
+	AllowHTTP bool            `json:"allow_http,omitempty"`
+	HSTS      *HSTSEntry      `json:"hsts,omitempty"`
+	WebSocket *WebSocketEntry `json:"websocket,omitempty"`
+	// StreamPaths are passed through as the backend sends them and never
+	// cached. text/event-stream responses are always streamed.
+	StreamPaths []string          `json:"stream_paths,omitempty"`
+	Cache       *CacheEntry       `json:"cache,omitempty"`
+	Compression *CompressionEntry `json:"compression,omitempty"`
+	RateLimit   *RateLimitEntry   `json:"rate_limit,omitempty"`
+}
+
+// HSTSEntry adds a Strict-Transport-Security header to HTTPS responses.
+type HSTSEntry struct {
+	MaxAge            int  `json:"max_age"`
+	IncludeSubDomains bool `json:"include_subdomains,omitempty"`
+	Preload           bool `json:"preload,omitempty"`
+}
+
+func (h *HSTSEntry) String() string {
+	value := "max-age=" + strconv.Itoa(h.MaxAge)
+	if h.IncludeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if h.Preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// MTLSEntry asks clients of a subdomain for a certificate signed by CABundle.
+// AllowedSubjects and AllowedSANs are glob patterns (path.Match syntax); when
+// set, the verified certificate must match at least one of them.
+type MTLSEntry struct {
+	CABundle        string   `json:"ca_bundle"`
+	Required        bool     `json:"required"`
+	AllowedSubjects []string `json:"allowed_subjects,omitempty"`
+	AllowedSANs     []string `json:"allowed_sans,omitempty"`
 }
 
 type VPSEntry struct {
 	IP string `json:"ip"`
 	// Capacity is a decimal value between 0.0 and 1.0 representing the proportion of requests to route to this backend.
 	// All capacities in a load balancer entry must sum to exactly 1.0 for proper load balancing.
-	Capacity float64 `json:"capacity"`
-	Active   bool    `json:"active"`
+	Capacity float64           `json:"capacity"`
+	Active   bool              `json:"active"`
+	TLS      *UpstreamTLSEntry `json:"tls,omitempty"`
 }
 
-var SERVERS map[string]*fiber.App = map[string]*fiber.App{
-	"HTTP":  fiber.New(fiber.Config{DisableStartupMessage: true}),
-	"HTTPS": fiber.New(fiber.Config{DisableStartupMessage: true}),
+// UpstreamTLSEntry configures the TLS connection to an https:// or wss://
+// backend. Certificates are verified unless InsecureSkipVerify is set.
+type UpstreamTLSEntry struct {
+	CABundle           string `json:"ca_bundle,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
 }
+
+// SERVERS holds one app per listener, keyed by ListenerEntry.String(). Use
+// SetServer, Servers and ClearServers, which guard it with serversMu.
+var SERVERS map[string]*fiber.App = map[string]*fiber.App{}
+var serversMu sync.RWMutex
 var Proxies map[string][]string = make(map[string][]string)
 var URL_ADMIN_PANEL string = "http://admin:4173"
 var CONFIG_PATH string = filepath.Join(".", "config", "proxy.config.json")
 var AdminUsername string
 var AdminPassword string
 
+// GetListeners returns the configured listeners, or the classic :80 and :443
+// pair when the config has none.
+func (cfg *Config) GetListeners() []ListenerEntry {
+	if len(cfg.Listeners) != 0 {
+		return cfg.Listeners
+	}
+
+	if !cfg.OnHTTPS {
+		return []ListenerEntry{{Port: 80, Protocol: "http"}}
+	}
+
+	return []ListenerEntry{
+		{Port: 80, Protocol: "http", RedirectToHTTPS: true},
+		{Port: 443, Protocol: "https"},
+	}
+}
+
+// Network returns the network and address to pass to net.Listen.
+func (l ListenerEntry) Network() (string, string) {
+	if l.UnixSocket != "" {
+		return "unix", l.UnixSocket
+	}
+
+	network := "tcp"
+	switch l.IPVersion {
+	case "ipv4":
+		network = "tcp4"
+	case "ipv6":
+		network = "tcp6"
+	}
+
+	return network, net.JoinHostPort(l.Address, strconv.Itoa(l.Port))
+}
+
+func (l ListenerEntry) String() string {
+	network, address := l.Network()
+	return l.Protocol + "://" + address + " (" + network + ")"
+}
+
+func validateListeners(cfg *Config) error {
+	httpsListeners := 0
+
+	for _, l := range cfg.Listeners {
+		switch l.Protocol {
+		case "http":
+		case "https":
+			httpsListeners++
+			if !cfg.OnHTTPS {
+				return fmt.Errorf("listener %s uses https but on_https is false", l)
+			}
+			if l.RedirectToHTTPS {
+				return fmt.Errorf("listener %s already uses https and can't redirect to it", l)
+			}
+		default:
+			return fmt.Errorf("listener protocol must be 'http' or 'https', got '%s'", l.Protocol)
+		}
+
+		if l.UnixSocket == "" && (l.Port < 1 || l.Port > 65535) {
+			return fmt.Errorf("listener %s needs a port between 1 and 65535", l)
+		}
+
+		if l.IPVersion != "" && l.IPVersion != "ipv4" && l.IPVersion != "ipv6" {
+			return fmt.Errorf("listener ip_version must be 'ipv4' or 'ipv6', got '%s'", l.IPVersion)
+		}
+	}
+
+	for _, l := range cfg.Listeners {
+		if l.RedirectToHTTPS && httpsListeners == 0 {
+			return fmt.Errorf("listener %s redirects to https but there is no https listener", l)
+		}
+	}
+
+	return nil
+}
+
+func SetServer(name string, app *fiber.App) {
+	serversMu.Lock()
+	defer serversMu.Unlock()
+
+	SERVERS[name] = app
+}
+
+// Servers returns a copy of SERVERS.
+func Servers() map[string]*fiber.App {
+	serversMu.RLock()
+	defer serversMu.RUnlock()
+
+	servers := make(map[string]*fiber.App, len(SERVERS))
+	for name, app := range SERVERS {
+		servers[name] = app
+	}
+	return servers
+}
+
+func ClearServers() {
+	serversMu.Lock()
+	defer serversMu.Unlock()
+
+	SERVERS = map[string]*fiber.App{}
+}
+
 func AllValuesNonEmpty(entry *LoadBalancerEntry) bool {
 	return entry.Type != "" && len(entry.VPS) != 0
 }
 
 func ValidateConfig(cfg *Config) error {
+	if err := validateListeners(cfg); err != nil {
+		return err
+	}
+
+	if _, err := cfg.TLS.Apply(&tls.Config{}); err != nil {
+		return fmt.Errorf("invalid tls configuration: %w", err)
+	}
+
+	if err := cfg.L1Cache.validate(); err != nil {
+		return fmt.Errorf("invalid l1_cache configuration: %w", err)
+	}
+
+	for _, warning := range TLSWarnings(cfg) {
+		fmt.Println("⚠️ ", warning)
+	}
+
+	// Backends listed under several subdomains share one client
+	upstreams := map[string]*UpstreamTLSEntry{}
+
 	if cfg.LoadBalancer != nil && len(cfg.LoadBalancer) != 0 {
 		for _, e := range cfg.LoadBalancer {
-			sum := 0.0
-			for _, v := range e.VPS {
-				if !v.Active {
-					fmt.Println("➖ Skipping inactive VPS:", v.IP)
-					continue
-				}
-
-				if v.Capacity > 1 || v.Capacity < 0 {
-					return fmt.Errorf("capacity for backend %s must be between 0.0 and 1.0 (inclusive), representing the proportion of requests to route to this backend", v.IP)
-				}
-				sum += v.Capacity
-			}
-			if sum != 1 {
-				fmt.Printf("❌ Sum of capacities for subdomain '%s' is %.2f, but must be 1.0\n", e.Subdomain, sum)
-				return fmt.Errorf("invalid load balancer configuration for subdomain '%s': sum of capacities must be 1.0", e.Subdomain)
-			} else {
-				fmt.Printf("✅ Load balancer for subdomain '%s' is correctly configured (sum = 1.0)\n", e.Subdomain)
-			}
-
-			// Validate cache paths
-			if e.CacheEnabled {
-				if len(e.CachePaths) == 0 {
-					return fmt.Errorf("cache enabled for subdomain '%s' but no cache paths specified", e.Subdomain)
-				}
-				for _, path := range e.CachePaths {
-					if !strings.HasPrefix(path, "/") {
-						return fmt.Errorf("cache path '%s' for subdomain '%s' must start with '/'", path, e.Subdomain)
-					}
-				}
+			if err := validateRoute(fmt.Sprintf("subdomain '%s'", e.Subdomain), e, upstreams); err != nil {
+				return err
 			}
 		}
 	} else {
@@ -93,37 +263,187 @@ func ValidateConfig(cfg *Config) error {
 	}
 
 	if cfg.RootLoadBalancer != nil && AllValuesNonEmpty(cfg.RootLoadBalancer) {
-		sum := 0.0
-		for _, v := range cfg.RootLoadBalancer.VPS {
-			if !v.Active {
-				continue
-			}
+		if err := validateRoute("the root domain", *cfg.RootLoadBalancer, upstreams); err != nil {
+			return err
+		}
+	}
 
-			if v.Capacity > 1 || v.Capacity < 0 {
-				return fmt.Errorf("capacity for backend %s must be between 0.0 and 1.0 (inclusive), representing the proportion of requests to route to this backend", v.IP)
-			}
-			sum += v.Capacity
+	return nil
+}
+
+// validateRoute checks a load balancer entry; name is how errors refer to it.
+// upstreams collects the TLS settings of the backends seen so far.
+func validateRoute(name string, e LoadBalancerEntry, upstreams map[string]*UpstreamTLSEntry) error {
+	sum := 0.0
+	for _, v := range e.VPS {
+		if !v.Active {
+			fmt.Println("➖ Skipping inactive VPS:", v.IP)
+			continue
+		}
+
+		if v.Capacity > 1 || v.Capacity < 0 {
+			return fmt.Errorf("capacity for backend %s must be between 0.0 and 1.0 (inclusive), representing the proportion of requests to route to this backend", v.IP)
 		}
-		if sum != 1 {
-			return fmt.Errorf("invalid root load balancer configuration: sum of capacities must be 1.0")
+		if err := validateUpstreamTLS(v, upstreams); err != nil {
+			return err
 		}
+		sum += v.Capacity
+	}
+	if sum != 1 {
+		fmt.Printf("❌ Sum of capacities for %s is %.2f, but must be 1.0\n", name, sum)
+		return fmt.Errorf("invalid load balancer configuration for %s: sum of capacities must be 1.0", name)
+	}
+	fmt.Printf("✅ Load balancer for %s is correctly configured (sum = 1.0)\n", name)
 
-		// Validate cache paths for root
-		if cfg.RootLoadBalancer.CacheEnabled {
-			if len(cfg.RootLoadBalancer.CachePaths) == 0 {
-				return fmt.Errorf("cache enabled for root load balancer but no cache paths specified")
-			}
-			for _, path := range cfg.RootLoadBalancer.CachePaths {
-				if !strings.HasPrefix(path, "/") {
-					return fmt.Errorf("cache path '%s' for root load balancer must start with '/'", path)
-				}
+	// Validate cache paths
+	if e.CacheEnabled {
+		if len(e.CachePaths) == 0 && (e.Cache == nil || len(e.Cache.Rules) == 0) {
+			return fmt.Errorf("cache enabled for %s but no cache paths specified", name)
+		}
+		for _, path := range e.CachePaths {
+			if !strings.HasPrefix(path, "/") {
+				return fmt.Errorf("cache path '%s' for %s must start with '/'", path, name)
 			}
 		}
 	}
 
+	for _, path := range e.StreamPaths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("stream path '%s' for %s must start with '/'", path, name)
+		}
+	}
+
+	if err := validateMTLS(e.MTLS); err != nil {
+		return fmt.Errorf("invalid mtls configuration for %s: %w", name, err)
+	}
+	if e.AllowHTTP && e.MTLS != nil && e.MTLS.Required {
+		return fmt.Errorf("%s requires client certificates and can't allow plain HTTP", name)
+	}
+	if err := validateHSTS(e.HSTS); err != nil {
+		return fmt.Errorf("invalid hsts configuration for %s: %w", name, err)
+	}
+	if _, err := e.Cache.Policy(); err != nil {
+		return fmt.Errorf("invalid cache configuration for %s: %w", name, err)
+	}
+	if _, err := e.Cache.CacheRules(); err != nil {
+		return fmt.Errorf("invalid cache rules for %s: %w", name, err)
+	}
+	if _, err := e.WebSocket.Limits(); err != nil {
+		return fmt.Errorf("invalid websocket configuration for %s: %w", name, err)
+	}
+	if _, err := e.Compression.Settings(); err != nil {
+		return fmt.Errorf("invalid compression configuration for %s: %w", name, err)
+	}
+	if _, err := e.RateLimit.Limit(); err != nil {
+		return fmt.Errorf("invalid rate_limit configuration for %s: %w", name, err)
+	}
+
 	return nil
 }
 
+func validateHSTS(entry *HSTSEntry) error {
+	if entry == nil {
+		return nil
+	}
+
+	if entry.MaxAge < 0 {
+		return fmt.Errorf("max_age can't be negative")
+	}
+
+	return nil
+}
+
+func validateMTLS(entry *MTLSEntry) error {
+	if entry == nil {
+		return nil
+	}
+
+	if _, err := LoadCertPool(entry.CABundle); err != nil {
+		return err
+	}
+
+	for _, pattern := range append(append([]string{}, entry.AllowedSubjects...), entry.AllowedSANs...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+func validateUpstreamTLS(v VPSEntry, seen map[string]*UpstreamTLSEntry) error {
+	if other, ok := seen[v.IP]; ok && !reflect.DeepEqual(other, v.TLS) {
+		return fmt.Errorf("backend %s is listed with different upstream tls settings", v.IP)
+	}
+	seen[v.IP] = v.TLS
+
+	if v.TLS == nil {
+		return nil
+	}
+
+	if (v.TLS.CertFile == "") != (v.TLS.KeyFile == "") {
+		return fmt.Errorf("upstream tls for backend %s needs both cert_file and key_file", v.IP)
+	}
+
+	if _, err := LoadUpstreamTLS(v.TLS); err != nil {
+		return fmt.Errorf("invalid upstream tls for backend %s: %w", v.IP, err)
+	}
+
+	if v.TLS.InsecureSkipVerify {
+		fmt.Printf("⚠️  Certificate verification is disabled for backend %s\n", v.IP)
+	}
+
+	return nil
+}
+
+// LoadUpstreamTLS builds the client TLS configuration for a backend.
+func LoadUpstreamTLS(entry *UpstreamTLSEntry) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if entry == nil {
+		return tlsConfig, nil
+	}
+
+	tlsConfig.ServerName = entry.ServerName
+	tlsConfig.InsecureSkipVerify = entry.InsecureSkipVerify
+
+	if entry.CABundle != "" {
+		pool, err := LoadCertPool(entry.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if entry.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(entry.CertFile, entry.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// LoadCertPool reads a PEM bundle of CA certificates.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	if file == "" {
+		return nil, fmt.Errorf("ca_bundle is required")
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA bundle '%s': %w", file, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA bundle '%s'", file)
+	}
+
+	return pool, nil
+}
+
 func ReadConfig() (*Config, error) {
 	data, err := os.ReadFile(CONFIG_PATH)
 	if err != nil {
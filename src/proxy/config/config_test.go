@@ -0,0 +1,198 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	backend := func(ip string, capacity float64) VPSEntry {
+		return VPSEntry{IP: ip, Capacity: capacity, Active: true}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			modify: func(cfg *Config) {},
+		},
+		{
+			name: "capacities don't sum 1",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].VPS = []VPSEntry{backend("http://10.0.0.1", 0.5), backend("http://10.0.0.2", 0.4)}
+			},
+			wantErr: "sum of capacities must be 1.0",
+		},
+		{
+			name: "capacity over 1",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].VPS = []VPSEntry{backend("http://10.0.0.1", 1.5)}
+			},
+			wantErr: "must be between 0.0 and 1.0",
+		},
+		{
+			name: "inactive backends don't count",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].VPS = append(cfg.LoadBalancer[0].VPS, VPSEntry{IP: "http://10.0.0.2", Capacity: 0.5})
+			},
+		},
+		{
+			name: "root domain",
+			modify: func(cfg *Config) {
+				cfg.RootLoadBalancer = &LoadBalancerEntry{Type: "random", VPS: []VPSEntry{backend("http://10.0.0.3", 0.5)}}
+			},
+			wantErr: "for the root domain",
+		},
+		{
+			name: "cache without paths",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].CachePaths = nil
+			},
+			wantErr: "no cache paths specified",
+		},
+		{
+			name: "cache with rules only",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].CachePaths = nil
+				cfg.LoadBalancer[0].Cache = &CacheEntry{Rules: []CacheRuleEntry{{Path: "/img/*", TTL: "1h"}}}
+			},
+		},
+		{
+			name: "relative cache path",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].CachePaths = []string{"static/*"}
+			},
+			wantErr: "must start with '/'",
+		},
+		{
+			name: "relative stream path",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].StreamPaths = []string{"events"}
+			},
+			wantErr: "stream path 'events'",
+		},
+		{
+			name: "invalid cache policy",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].Cache = &CacheEntry{MaxTTL: "forever"}
+			},
+			wantErr: "invalid cache configuration for subdomain 'app'",
+		},
+		{
+			name: "invalid cache rule",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].Cache = &CacheEntry{Rules: []CacheRuleEntry{{Path: "/*", StatusTTL: map[string]string{"6xx": "1m"}}}}
+			},
+			wantErr: "invalid cache rules for subdomain 'app'",
+		},
+		{
+			name: "invalid hsts",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].HSTS = &HSTSEntry{MaxAge: -1}
+			},
+			wantErr: "invalid hsts configuration",
+		},
+		{
+			name: "invalid websocket limits",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].WebSocket = &WebSocketEntry{IdleTimeout: "1 minute"}
+			},
+			wantErr: "invalid websocket configuration",
+		},
+		{
+			name: "invalid compression",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].Compression = &CompressionEntry{Encodings: []string{"zstd"}}
+			},
+			wantErr: "invalid compression configuration",
+		},
+		{
+			name: "compression min_size over max_size",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].Compression = &CompressionEntry{MinSize: 2048, MaxSize: 1024}
+			},
+			wantErr: "invalid compression configuration",
+		},
+		{
+			name: "rate limit",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].RateLimit = &RateLimitEntry{Requests: 100, Window: "1m", Block: "5m"}
+			},
+		},
+		{
+			name: "rate limit without requests",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].RateLimit = &RateLimitEntry{Window: "1m"}
+			},
+			wantErr: "invalid rate_limit configuration",
+		},
+		{
+			name: "rate limit with an empty window",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].RateLimit = &RateLimitEntry{Requests: 10, Window: "0s"}
+			},
+			wantErr: "window must be greater than 0",
+		},
+		{
+			name: "upstream tls cert without key",
+			modify: func(cfg *Config) {
+				cfg.LoadBalancer[0].VPS[0].TLS = &UpstreamTLSEntry{CertFile: "client.pem"}
+			},
+			wantErr: "needs both cert_file and key_file",
+		},
+		{
+			name: "backend with conflicting upstream tls",
+			modify: func(cfg *Config) {
+				other := LoadBalancerEntry{Subdomain: "other", Type: "random", VPS: []VPSEntry{backend("http://10.0.0.1", 1)}}
+				other.VPS[0].TLS = &UpstreamTLSEntry{ServerName: "backend.internal"}
+				cfg.LoadBalancer = append(cfg.LoadBalancer, other)
+			},
+			wantErr: "different upstream tls settings",
+		},
+		{
+			name: "https listener without on_https",
+			modify: func(cfg *Config) {
+				cfg.Listeners = []ListenerEntry{{Port: 8443, Protocol: "https"}}
+			},
+			wantErr: "on_https is false",
+		},
+		{
+			name: "negative l1 cache size",
+			modify: func(cfg *Config) {
+				cfg.L1Cache = &L1CacheEntry{MaxSize: -1}
+			},
+			wantErr: "invalid l1_cache configuration",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Hostname: "dev.space",
+				LoadBalancer: []LoadBalancerEntry{{
+					Subdomain:    "app",
+					Type:         "random",
+					Active:       true,
+					VPS:          []VPSEntry{backend("http://10.0.0.1", 1)},
+					CacheEnabled: true,
+					CachePaths:   []string{"/static/*"},
+				}},
+			}
+			tt.modify(cfg)
+
+			err := ValidateConfig(cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// RateLimitEntry limits how many requests each client IP may send to a
+// subdomain per Window. An IP over the limit gets 429 until the window ends,
+// or for Block when it is set. Durations use time.ParseDuration syntax.
+type RateLimitEntry struct {
+	Requests int    `json:"requests"`
+	Window   string `json:"window,omitempty"`
+	Block    string `json:"block,omitempty"`
+}
+
+type RateLimit struct {
+	Requests int
+	Window   time.Duration
+	Block    time.Duration
+}
+
+// DefaultRateLimitWindow is used when rate_limit doesn't set window.
+const DefaultRateLimitWindow = time.Minute
+
+func (e *RateLimitEntry) Limit() (RateLimit, error) {
+	limit := RateLimit{Window: DefaultRateLimitWindow}
+	if e == nil {
+		return limit, nil
+	}
+
+	if e.Requests <= 0 {
+		return limit, fmt.Errorf("requests must be greater than 0")
+	}
+	limit.Requests = e.Requests
+
+	durations := []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"window", e.Window, &limit.Window},
+		{"block", e.Block, &limit.Block},
+	}
+
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return limit, fmt.Errorf("invalid %s '%s': %w", d.name, d.value, err)
+		}
+		if parsed < 0 {
+			return limit, fmt.Errorf("%s can't be negative", d.name)
+		}
+		*d.dest = parsed
+	}
+
+	if limit.Window == 0 {
+		return limit, fmt.Errorf("window must be greater than 0")
+	}
+
+	return limit, nil
+}
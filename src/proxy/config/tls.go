@@ -0,0 +1,143 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// TLSEntry hardens the HTTPS listeners. Empty fields keep Go's defaults.
+type TLSEntry struct {
+	MinVersion       string   `json:"min_version,omitempty"`
+	CipherSuites     []string `json:"cipher_suites,omitempty"`
+	CurvePreferences []string `json:"curve_preferences,omitempty"`
+	ALPN             []string `json:"alpn,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519":         tls.X25519,
+	"P256":           tls.CurveP256,
+	"P384":           tls.CurveP384,
+	"P521":           tls.CurveP521,
+	"X25519MLKEM768": tls.X25519MLKEM768,
+}
+
+// Apply copies the profile into tlsConfig. A nil profile leaves it untouched.
+func (t *TLSEntry) Apply(tlsConfig *tls.Config) (*tls.Config, error) {
+	if t == nil {
+		return tlsConfig, nil
+	}
+
+	if t.MinVersion != "" {
+		version, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown min_version '%s', use 1.0, 1.1, 1.2 or 1.3", t.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if len(t.CipherSuites) != 0 {
+		tlsConfig.CipherSuites = nil
+		for _, name := range t.CipherSuites {
+			suite := findCipherSuite(name)
+			if suite == nil {
+				return nil, fmt.Errorf("unknown cipher suite '%s'", name)
+			}
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, suite.ID)
+		}
+	}
+
+	if len(t.CurvePreferences) != 0 {
+		tlsConfig.CurvePreferences = nil
+		for _, name := range t.CurvePreferences {
+			curve, ok := tlsCurves[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown curve '%s'", name)
+			}
+			tlsConfig.CurvePreferences = append(tlsConfig.CurvePreferences, curve)
+		}
+	}
+
+	if len(t.ALPN) != 0 {
+		for _, proto := range t.ALPN {
+			if proto != "http/1.1" {
+				return nil, fmt.Errorf("unsupported ALPN protocol '%s', only http/1.1 is served", proto)
+			}
+		}
+		tlsConfig.NextProtos = t.ALPN
+	}
+
+	return tlsConfig, nil
+}
+
+// TLSWarnings lists settings that are valid but weak.
+func TLSWarnings(cfg *Config) []string {
+	warnings := []string{}
+
+	if t := cfg.TLS; t != nil {
+		if t.MinVersion == "1.0" || t.MinVersion == "1.1" {
+			warnings = append(warnings, fmt.Sprintf("tls min_version %s is deprecated, use 1.2 or higher", t.MinVersion))
+		}
+
+		for _, name := range t.CipherSuites {
+			suite := findCipherSuite(name)
+			if suite == nil {
+				continue
+			}
+			if suite.Insecure {
+				warnings = append(warnings, fmt.Sprintf("cipher suite %s is insecure", name))
+			} else if !strings.Contains(name, "ECDHE") {
+				warnings = append(warnings, fmt.Sprintf("cipher suite %s has no forward secrecy", name))
+			} else if strings.Contains(name, "_CBC_") {
+				warnings = append(warnings, fmt.Sprintf("cipher suite %s uses CBC mode", name))
+			}
+		}
+	}
+
+	entries := append([]LoadBalancerEntry{}, cfg.LoadBalancer...)
+	if cfg.RootLoadBalancer != nil {
+		entries = append(entries, *cfg.RootLoadBalancer)
+	}
+
+	for _, e := range entries {
+		if e.HSTS == nil {
+			continue
+		}
+
+		name := e.Subdomain
+		if name == "" {
+			name = "root"
+		}
+
+		if e.HSTS.MaxAge < 31536000 && e.HSTS.Preload {
+			warnings = append(warnings, fmt.Sprintf("hsts for '%s' uses preload, which requires max_age of at least 31536000", name))
+		}
+		if e.HSTS.Preload && !e.HSTS.IncludeSubDomains {
+			warnings = append(warnings, fmt.Sprintf("hsts for '%s' uses preload, which requires include_subdomains", name))
+		}
+		if e.AllowHTTP {
+			warnings = append(warnings, fmt.Sprintf("hsts for '%s' makes browsers skip its plain HTTP (allow_http)", name))
+		}
+	}
+
+	return warnings
+}
+
+func findCipherSuite(name string) *tls.CipherSuite {
+	all := slices.Concat(tls.CipherSuites(), tls.InsecureCipherSuites())
+	for _, suite := range all {
+		if suite.Name == name {
+			return suite
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// WebSocketEntry limits the WebSocket tunnels of a subdomain. Durations use
+// time.ParseDuration syntax ("30s", "5m"); "0" disables the limit.
+type WebSocketEntry struct {
+	IdleTimeout        string `json:"idle_timeout,omitempty"`
+	PingInterval       string `json:"ping_interval,omitempty"`
+	MaxMessageSize     int64  `json:"max_message_size,omitempty"`
+	MaxSessionDuration string `json:"max_session_duration,omitempty"`
+}
+
+type WebSocketLimits struct {
+	IdleTimeout        time.Duration
+	PingInterval       time.Duration
+	MaxMessageSize     int64
+	MaxSessionDuration time.Duration
+}
+
+// DefaultPingInterval keeps tunnels alive and detects half-open connections
+// when the route doesn't set ping_interval.
+const DefaultPingInterval = 30 * time.Second
+
+func (w *WebSocketEntry) Limits() (WebSocketLimits, error) {
+	limits := WebSocketLimits{PingInterval: DefaultPingInterval}
+	if w == nil {
+		return limits, nil
+	}
+
+	if w.MaxMessageSize < 0 {
+		return limits, fmt.Errorf("max_message_size can't be negative")
+	}
+	limits.MaxMessageSize = w.MaxMessageSize
+
+	durations := []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"idle_timeout", w.IdleTimeout, &limits.IdleTimeout},
+		{"ping_interval", w.PingInterval, &limits.PingInterval},
+		{"max_session_duration", w.MaxSessionDuration, &limits.MaxSessionDuration},
+	}
+
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return limits, fmt.Errorf("invalid %s '%s': %w", d.name, d.value, err)
+		}
+		if parsed < 0 {
+			return limits, fmt.Errorf("%s can't be negative", d.name)
+		}
+		*d.dest = parsed
+	}
+
+	return limits, nil
+}
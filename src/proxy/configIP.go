@@ -5,7 +5,6 @@ import (
 	"mixproxy/src/proxy/config"
 	"mixproxy/src/proxy/tools"
 	"mixproxy/src/redis"
-	"os"
 )
 
 type LoadBalancer struct {
@@ -23,21 +22,26 @@ func reloadConfig() {
 	redis.Clean()
 
 	if err := config.ValidateConfig(cfg); err != nil {
-		log.Println("❌ Error de validación:", err)
-		os.Exit(0)
+		log.Fatalln("❌ Error de validación:", err)
 	}
 
+	redis.SetupL1Cache(cfg.L1Cache.Sizes())
+
 	loadBalancer := map[string]*[]LoadBalancer{}
+	mtls := map[string]*config.MTLSEntry{}
+	upstreams := map[string]*config.UpstreamTLSEntry{}
+	entries := map[string]config.LoadBalancerEntry{}
 
 	if cfg.ModeDeveloper {
 		log.Println("Configuring certificates in development mode")
 	}
 
 	for _, e := range cfg.LoadBalancer {
-		vps := []LoadBalancer{}
 		subdomain := e.Subdomain
 		redis.SetAllowSubdomainToUseCache(subdomain, e.CacheEnabled)
 		redis.SetCachePaths(subdomain, e.CachePaths)
+		mtls[subdomain] = e.MTLS
+		entries[subdomain] = e
 
 		if e.WhitelistsEnabled {
 			redis.EnabledWhitelistForSubdomain(e.Subdomain)
@@ -51,46 +55,27 @@ func reloadConfig() {
 			redis.DisabledBlacklistForSubdomain(e.Subdomain)
 		}
 
-		for _, v := range e.VPS {
-			vps = append(vps, LoadBalancer{
-				URL:      v.IP,
-				Capacity: v.Capacity,
-			})
-		}
-
-		loadBalancer[subdomain] = &vps
-		probability := []tools.VpsProbability{}
-		for _, v := range e.VPS {
-			probability = append(probability, tools.VpsProbability{
-				Probability: v.Capacity,
-				IP:          v.IP,
-			})
-		}
-		tools.SetupServerSelected(subdomain, probability)
+		loadBalancer[subdomain] = setupBackends(subdomain, e.VPS, upstreams)
 	}
 
 	if cfg.RootLoadBalancer != nil && config.AllValuesNonEmpty(cfg.RootLoadBalancer) {
-		vps := []LoadBalancer{}
 		subdomain := ""
 		redis.SetAllowSubdomainToUseCache(subdomain, cfg.RootLoadBalancer.CacheEnabled)
 		redis.SetCachePaths(subdomain, cfg.RootLoadBalancer.CachePaths)
+		mtls[subdomain] = cfg.RootLoadBalancer.MTLS
+		entries[subdomain] = *cfg.RootLoadBalancer
 
-		for _, v := range cfg.RootLoadBalancer.VPS {
-			vps = append(vps, LoadBalancer{
-				URL:      v.IP,
-				Capacity: v.Capacity,
-			})
-		}
+		loadBalancer[subdomain] = setupBackends(subdomain, cfg.RootLoadBalancer.VPS, upstreams)
+	}
 
-		loadBalancer[subdomain] = &vps
-		probability := []tools.VpsProbability{}
-		for _, v := range cfg.RootLoadBalancer.VPS {
-			probability = append(probability, tools.VpsProbability{
-				Probability: v.Capacity,
-				IP:          v.IP,
-			})
-		}
-		tools.SetupServerSelected(subdomain, probability)
+	setupRoutes(entries)
+
+	if err := setupClientAuths(mtls); err != nil {
+		log.Fatalln("❌ Error loading mTLS settings:", err)
+	}
+
+	if err := setupUpstreams(upstreams); err != nil {
+		log.Fatalln("❌ Error loading upstream TLS settings:", err)
 	}
 
 	if len(loadBalancer) != 0 {
@@ -100,4 +85,40 @@ func reloadConfig() {
 			}
 		}
 	}
+
+	// La recarga vacía la caché; se vuelve a llenar con las URLs configuradas
+	for subdomain, e := range entries {
+		if e.CacheEnabled && e.Cache != nil && e.Cache.Warm != nil && e.Cache.Warm.OnReload {
+			if err := startWarming(subdomain, nil, ""); err != nil {
+				log.Println("❌ Error warming the cache:", err)
+			}
+		}
+	}
+}
+
+// setupBackends puts the active backends of the subdomain in the rotation and
+// collects their upstream TLS settings. Inactive backends get no traffic, as
+// ValidateConfig skips them.
+func setupBackends(subdomain string, backends []config.VPSEntry, upstreams map[string]*config.UpstreamTLSEntry) *[]LoadBalancer {
+	vps := []LoadBalancer{}
+	probability := []tools.VpsProbability{}
+	for _, v := range backends {
+		if !v.Active {
+			continue
+		}
+
+		vps = append(vps, LoadBalancer{
+			URL:      v.IP,
+			Capacity: v.Capacity,
+		})
+		probability = append(probability, tools.VpsProbability{
+			Probability: v.Capacity,
+			IP:          v.IP,
+		})
+		upstreams[v.IP] = v.TLS
+	}
+
+	tools.SetupServerSelected(subdomain, probability)
+
+	return &vps
 }
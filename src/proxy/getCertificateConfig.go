@@ -1,5 +0,0 @@
-package proxy
-
-func getCertificateConfig() (string, string) {
-	return "./certs/localhost.pem", "./certs/localhost-key.pem"
-}
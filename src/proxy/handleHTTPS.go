@@ -1,10 +1,8 @@
 package proxy
 
 import (
-	"encoding/base64"
-	"log"
 	"mixproxy/src/logger"
-	"mixproxy/src/proxy/config"
+	"mixproxy/src/proxy/cachestats"
 	"mixproxy/src/redis"
 	"strings"
 	"time"
@@ -15,86 +13,101 @@ import (
 )
 
 func handleHTTPS(c *fiber.Ctx) error {
-	if websocket.IsWebSocketUpgrade(c) {
-		return c.Next()
-	}
-
 	subdomain, host := getSubdomainAndHost(c)
 
-	if isEnabled, _ := redis.IsEnabledWhitelistForSubdomain(subdomain); isEnabled {
-		_, err := redis.GetIPForWhitelist(subdomain, c.IP())
+	// Las peticiones internas (refrescos de la caché) ya pasaron los controles
+	if !isInternalRequest(c) {
+		if !verifyClientCertificate(c, subdomain) {
+			return nil
+		}
 
-		if err != nil {
-			return c.SendString("You are not on the whitelist")
+		if !checkAccess(c, subdomain) {
+			return nil
 		}
 	}
 
-	// Check global blacklist
-	_, err := redis.GetIPForGlobalBlacklist(c.IP())
-	if err == nil {
-		return c.SendString("You are on the global blacklist")
+	if c.Method() == methodPurge {
+		return handlePurge(c, subdomain)
 	}
 
-	if isEnabled, _ := redis.IsEnabledBlacklistForSubdomain(subdomain); isEnabled {
-		reason, err := redis.GetIPForBlacklist(subdomain, c.IP())
-		if err == nil {
-			return c.SendString("You are on the blacklist\n" + reason.Content)
-		}
+	if websocket.IsWebSocketUpgrade(c) {
+		return handleWebSocket(c)
 	}
 
-	if c.Method() == "GET" {
-		// Check cache for non-admin GET requests
-		key := generateCacheKey(c)
-		cached, found, err := redis.GetCachedResponse(key)
-		if err != nil {
-			log.Printf("Redis error: %v", err)
-		} else if found {
-			c.Status(cached.Status)
-			for k, v := range cached.Headers {
-				c.Set(k, v)
+	var stale *redis.CachedResponse
+	key := ""
+
+	if (c.Method() == "GET" || c.Method() == "HEAD") && !isStreamingRequest(c, subdomain) && !cacheBypassed(c, subdomain) {
+		// Check cache for non-admin GET requests; HEAD uses the GET entries
+		cached, found := lookupCache(c)
+		key = cached.Key
+		if found {
+			age := time.Since(cached.Created)
+
+			if cached.IsFresh() && requestAcceptsCached(c, age, cached.Expires) {
+				return respondFromCache(c, cached, host, subdomain, cachestats.Hit)
 			}
-			// Set Server header for cached response
-			subdomain, _ := getSubdomainAndHost(c)
-			if redis.DoesTheSubdomainAllowCache(subdomain) {
-				c.Set(fiber.HeaderServer, "Mixproxy (with cache)")
-			} else {
-				c.Set(fiber.HeaderServer, "Mixproxy")
+
+			// Caducada pero dentro del margen: se sirve y se refresca aparte
+			if time.Now().Before(cached.StaleWhileRevalidate) && requestAcceptsCached(c, age, cached.Expires) {
+				refreshInBackground(c)
+				return respondFromCache(c, cached, host, subdomain, cachestats.Stale)
+			}
+
+			stale = &cached
+		}
+	}
+
+	// Solo una petición por variante va al backend; las demás esperan su resultado
+	var leading *flight
+	if timeout := coalesceTimeout(c, subdomain); timeout > 0 {
+		if key == "" {
+			key = generateCacheKey(c)
+		}
+		if f, leader := joinFlight(key); leader {
+			leading = f
+			defer f.land()
+		} else if f.wait(timeout) {
+			if cached, found := lookupCache(c); found && cached.IsFresh() && requestAcceptsCached(c, time.Since(cached.Created), cached.Expires) {
+				return respondFromCache(c, cached, host, subdomain, cachestats.Hit)
 			}
-			logger.AddRequestLog(c.Method(), host+c.OriginalURL(), c.IP(), subdomain, c.Response().StatusCode(), true)
-			return c.SendString(cached.Body)
 		}
 	}
 
 	url, err := getHandleFunc(c)
 	if err != nil {
+		if canServeStaleIfError(stale) {
+			return respondFromCache(c, *stale, host, subdomain, cachestats.Stale)
+		}
 		return err
 	}
 
 	logger.AddRequestLog(c.Method(), host+c.OriginalURL(), c.IP(), subdomain, c.Response().StatusCode(), false)
 
-	if strings.Contains(url, "admin") {
-		auth := c.Get("Authorization")
-		if auth == "" || !strings.HasPrefix(auth, "Basic ") {
-			c.Status(401).Set("WWW-Authenticate", `Basic realm="Admin"`)
-			return c.SendString("Unauthorized")
-		}
-		encoded := strings.TrimPrefix(auth, "Basic ")
-		decoded, err := base64.StdEncoding.DecodeString(encoded)
-		if err != nil {
-			c.Status(401)
-			return c.SendString("Unauthorized")
-		}
-		creds := string(decoded)
-		parts := strings.SplitN(creds, ":", 2)
-		if len(parts) != 2 || parts[0] != config.AdminUsername || parts[1] != config.AdminPassword {
-			c.Status(401)
-			return c.SendString("Unauthorized")
-		}
+	if strings.Contains(url, "admin") && !checkAdminAuth(c) {
+		return nil
 	}
 
 	// c.Request().Header.Set("Host", c.Hostname())
 
-	if err := proxy.Do(c, url+c.OriginalURL(), client); err != nil {
+	// Caducada: se pregunta al backend si sigue siendo válida
+	var r *revalidation
+	if stale != nil && stale.HasValidators() {
+		r = startRevalidation(c, *stale)
+	}
+
+	restoreAcceptEncoding := withoutUpstreamCompression(c, subdomain)
+	err = proxy.Do(c, url+c.OriginalURL(), getUpstreamClient(url))
+	restoreAcceptEncoding()
+
+	revalidated := r != nil && r.finish(c)
+
+	// Con el backend caído se sirve la copia caducada si está permitido
+	if (err != nil || c.Response().StatusCode() >= fiber.StatusInternalServerError) && canServeStaleIfError(stale) {
+		c.Response().Reset()
+		return respondFromCache(c, *stale, host, subdomain, cachestats.Stale)
+	}
+	if err != nil {
 		return err
 	}
 
@@ -105,22 +118,41 @@ func handleHTTPS(c *fiber.Ctx) error {
 		c.Set(fiber.HeaderServer, "Mixproxy")
 	}
 
+	// El cuerpo sigue llegando del backend; se envía al cliente sin almacenarlo
+	if isStreamingResponse(c, subdomain) {
+		setCacheResult(c, subdomain, cachestats.Bypass)
+		return nil
+	}
+
 	// Cache the response if GET, not admin and cacheable
+	var stored *redis.CachedResponse
 	if c.Method() == "GET" && !strings.Contains(url, "admin") && isCacheable(c) {
-		key := generateCacheKey(c)
-		resp := redis.CachedResponse{
-			Status:  c.Response().StatusCode(),
-			Headers: make(map[string]string),
-			Body:    string(c.Response().Body()),
-		}
-		c.Response().Header.VisitAll(func(key, value []byte) {
-			resp.Headers[string(key)] = string(value)
-		})
-		ttl := 15 * time.Minute
-		if err := redis.SetCachedResponse(key, resp, ttl); err != nil {
-			log.Printf("Failed to cache response: %v", err)
-		}
+		stored = storeCache(c, subdomain)
+	}
+
+	// Las que esperan ya pueden seguir, haya quedado guardada la respuesta o no
+	if leading != nil {
+		leading.land()
+	}
+
+	switch {
+	case revalidated:
+		setCacheResult(c, subdomain, cachestats.Revalidated)
+	case (c.Method() == "GET" || c.Method() == "HEAD") && cachesPath(c):
+		setCacheResult(c, subdomain, cachestats.Miss)
+	default:
+		setCacheResult(c, subdomain, cachestats.Bypass)
 	}
 
+	removeCacheTagHeaders(c)
+
+	if r != nil && notModified(c) {
+		c.Status(fiber.StatusNotModified)
+		c.Response().ResetBody()
+		return nil
+	}
+
+	compressResponse(c, subdomain, stored)
+
 	return nil
 }
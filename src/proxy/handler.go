@@ -4,7 +4,6 @@ import (
 	"log"
 	"mixproxy/src/proxy/config"
 	"mixproxy/src/proxy/tools"
-	"os"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
@@ -15,9 +14,7 @@ var cfg *config.Config
 func InitStartConfig() {
 	config, err := config.ReadConfig()
 	if err != nil {
-		log.Println("Not found config")
-
-		os.Exit(0)
+		log.Fatalln("Not found config:", err)
 	}
 
 	cfg = config
@@ -28,8 +25,8 @@ func getSubdomain(ctx *fiber.Ctx) string {
 	host := strings.Split(hostAndPort, "//")[1]
 	subdomain := ""
 
-	if host != cfg.Hostname {
-		subdomain = strings.Split(host, ".")[0]
+	if hostname := tools.StripPort(host); hostname != cfg.Hostname {
+		subdomain = strings.Split(hostname, ".")[0]
 	}
 
 	return subdomain
@@ -40,8 +37,8 @@ func getSubdomainAndHost(ctx *fiber.Ctx) (string, string) {
 	host := strings.Split(hostAndPort, "//")[1]
 	subdomain := ""
 
-	if host != cfg.Hostname {
-		subdomain = strings.Split(host, ".")[0]
+	if hostname := tools.StripPort(host); hostname != cfg.Hostname {
+		subdomain = strings.Split(hostname, ".")[0]
 	}
 
 	return subdomain, host
@@ -1,118 +1,269 @@
 package proxy
 
 import (
-	"crypto/tls"
+	"errors"
 	"log"
-	"mixproxy/src/proxy/tools"
-	"mixproxy/src/redis"
+	"mixproxy/src/logger"
+	"mixproxy/src/proxy/config"
+	"mixproxy/src/proxy/sessions"
+	"net"
+	"net/http"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	fws "github.com/fasthttp/websocket"
-	"github.com/gofiber/websocket/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
 )
 
-func getSubdomainFromWebSocket(ctx *websocket.Conn) string {
-	origin := ctx.Headers("Origin")
-	originSplit := strings.Split(origin, "://")[1]
-
-	if originSplit == cfg.Hostname {
-		return ""
-	}
+// Headers that belong to the client handshake or the client connection and
+// must not be copied to the handshake with the backend.
+var webSocketSkippedHeaders = map[string]bool{
+	"Host":                     true,
+	"Connection":               true,
+	"Upgrade":                  true,
+	"Keep-Alive":               true,
+	"Proxy-Connection":         true,
+	"Te":                       true,
+	"Trailer":                  true,
+	"Transfer-Encoding":        true,
+	"Sec-Websocket-Key":        true,
+	"Sec-Websocket-Version":    true,
+	"Sec-Websocket-Extensions": true,
+}
 
-	subdomain := strings.Split(strings.Split(origin, "://")[1], "."+cfg.Hostname)[0]
+func toWebSocketURL(target string) string {
+	target = strings.Replace(target, "http://", "ws://", 1)
+	target = strings.Replace(target, "https://", "wss://", 1)
 
-	return subdomain
+	return target
 }
 
-func getHandleFuncFromWebSocket(ctx *websocket.Conn) (string, error) {
-	subdomain := getSubdomainFromWebSocket(ctx)
+// handleWebSocket routes an upgrade request through the same route table as
+// HTTP, opens the connection to the backend with the original path, query and
+// headers, and only then upgrades the client with the subprotocol chosen by
+// the backend.
+func handleWebSocket(c *fiber.Ctx) error {
+	subdomain, host := getSubdomainAndHost(c)
 
-	target, err := tools.GetTargetIPForSubdomain(subdomain)
+	backend, err := getHandleFunc(c)
 	if err != nil {
-		return "", err
+		log.Printf("Error obtaining URL for WebSocket: %v", err)
+		return c.Status(fiber.StatusBadGateway).SendString("Bad Gateway")
 	}
 
-	target = strings.Replace(target, "http://", "ws://", 1)
-	target = strings.Replace(target, "https://", "wss://", 1)
+	if strings.Contains(backend, "admin") && !checkAdminAuth(c) {
+		return nil
+	}
 
-	return target, nil
-}
+	target := toWebSocketURL(backend) + c.OriginalURL()
 
-// Nueva función para manejar WebSockets
-func handleWebSocket(c *websocket.Conn) {
-	defer c.Close()
+	header := http.Header{}
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		k := http.CanonicalHeaderKey(string(key))
+		if !webSocketSkippedHeaders[k] {
+			header.Add(k, string(value))
+		}
+	})
+	header.Set("X-Forwarded-For", c.IP())
+	header.Set("X-Forwarded-Host", host)
+	header.Set("X-Forwarded-Proto", c.Protocol())
 
-	url, err := getHandleFuncFromWebSocket(c)
+	route, _ := getRoute(subdomain)
+	limits, err := route.WebSocket.Limits()
 	if err != nil {
-		log.Printf("Error obtaining URL for WebSocket: %v", err)
-		return
+		log.Printf("Invalid WebSocket limits for subdomain '%s': %v", subdomain, err)
+	}
+
+	dialer := fws.Dialer{HandshakeTimeout: 45 * time.Second}
+	if strings.HasPrefix(target, "wss://") {
+		dialer.TLSClientConfig = getUpstreamTLSConfig(backend)
+	}
+
+	serverConn, resp, err := dialer.Dial(target, header)
+	if err != nil {
+		log.Printf("Error connecting to the WebSocket server: %v", err)
+		if resp != nil {
+			return c.Status(resp.StatusCode).SendString(http.StatusText(resp.StatusCode))
+		}
+		return c.Status(fiber.StatusBadGateway).SendString("Bad Gateway")
+	}
+
+	// El backend ya negoció el subprotocolo; el cliente recibe el mismo
+	upgrader := fws.FastHTTPUpgrader{
+		CheckOrigin: func(ctx *fasthttp.RequestCtx) bool { return true },
+	}
+	if subprotocol := serverConn.Subprotocol(); subprotocol != "" {
+		upgrader.Subprotocols = []string{subprotocol}
 	}
 
-	subdomain := getSubdomainFromWebSocket(c)
+	logger.AddRequestLog(c.Method(), host+c.OriginalURL(), c.IP(), subdomain, fiber.StatusSwitchingProtocols, false)
 
-	// Check global blacklist
-	_, err = redis.GetIPForGlobalBlacklist(c.RemoteAddr().String())
-	if err == nil {
-		c.WriteMessage(websocket.CloseMessage, []byte("You are on the global blacklist"))
-		return
+	clientIP := c.IP()
+	path := c.OriginalURL()
+
+	err = upgrader.Upgrade(c.Context(), func(clientConn *fws.Conn) {
+		defer clientConn.Close()
+		defer serverConn.Close()
+
+		session := sessions.Add(clientIP, subdomain, backend, path, func() {
+			closeWebSocket(clientConn, serverConn, fws.CloseNormalClosure, "closed by administrator")
+		})
+		defer sessions.Remove(session)
+
+		tunnelWebSocket(session, clientConn, serverConn, limits)
+	})
+	if err != nil {
+		serverConn.Close()
+		return err
 	}
 
-	// Check subdomain blacklist
-	if isEnabled, _ := redis.IsEnabledBlacklistForSubdomain(subdomain); isEnabled {
-		_, err = redis.GetIPForBlacklist(subdomain, c.RemoteAddr().String())
-		if err == nil {
-			c.WriteMessage(websocket.CloseMessage, []byte("You are on the blacklist"))
+	return nil
+}
+
+// closeWebSocket sends a close frame to both sides and closes the connections.
+func closeWebSocket(clientConn, serverConn *fws.Conn, code int, reason string) {
+	message := fws.FormatCloseMessage(code, reason)
+	deadline := time.Now().Add(time.Second)
+
+	clientConn.WriteControl(fws.CloseMessage, message, deadline)
+	serverConn.WriteControl(fws.CloseMessage, message, deadline)
+
+	clientConn.Close()
+	serverConn.Close()
+}
+
+// pipeResult is how a direction of the tunnel ended.
+type pipeResult struct {
+	src, dst *fws.Conn
+	err      error
+	writing  bool
+}
+
+// pipeWebSocket copies messages from src to dst until either side fails.
+func pipeWebSocket(src, dst *fws.Conn, messages, bytes, lastActivity *atomic.Int64, pongWait time.Duration, results chan<- pipeResult) {
+	for {
+		messageType, message, err := src.ReadMessage()
+		if err != nil {
+			results <- pipeResult{src: src, dst: dst, err: err}
+			return
+		}
+		if pongWait > 0 {
+			src.SetReadDeadline(time.Now().Add(pongWait))
+		}
+		messages.Add(1)
+		bytes.Add(int64(len(message)))
+		lastActivity.Store(time.Now().UnixNano())
+
+		if err := dst.WriteMessage(messageType, message); err != nil {
+			results <- pipeResult{src: src, dst: dst, err: err, writing: true}
 			return
 		}
 	}
+}
+
+// closeFor returns the close code and reason to send to the other side when a
+// direction of the tunnel ends, propagating the peer's own close frame.
+func closeFor(result pipeResult) (int, string) {
+	if result.writing {
+		return fws.CloseGoingAway, "peer went away"
+	}
 
-	// Verificar si la URL es wss:// o ws:// y configurar el Dialer
-	dialer := fws.Dialer{}
-	if strings.HasPrefix(url, "wss://") {
-		dialer.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true, // Esto permite conexiones inseguras, pero no es recomendado para producción
+	var closeErr *fws.CloseError
+	if errors.As(result.err, &closeErr) {
+		switch closeErr.Code {
+		case fws.CloseAbnormalClosure, fws.CloseTLSHandshake:
+			return fws.CloseGoingAway, "peer went away"
 		}
+		return closeErr.Code, closeErr.Text
 	}
 
-	serverConn, _, err := dialer.Dial(url, nil)
-	if err != nil {
-		log.Printf("Error connecting to the WebSocket server: %v", err)
-		return
+	if errors.Is(result.err, fws.ErrReadLimit) {
+		return fws.CloseMessageTooBig, "message too big"
 	}
-	defer serverConn.Close()
 
-	// Túnel entre cliente y servidor
-	done := make(chan struct{})
+	var netErr net.Error
+	if errors.As(result.err, &netErr) && netErr.Timeout() {
+		return fws.CloseGoingAway, "ping timeout"
+	}
 
-	go func() {
-		defer close(done)
-		for {
-			messageType, message, err := c.ReadMessage()
-			if err != nil {
-				log.Printf("Error reading client message: %v", err)
-				break
-			}
-			if err := serverConn.WriteMessage(messageType, message); err != nil {
-				log.Printf("Error writing message to server: %v", err)
-				break
-			}
+	return fws.CloseGoingAway, "peer went away"
+}
+
+// Túnel entre cliente y servidor
+func tunnelWebSocket(session *sessions.Session, clientConn, serverConn *fws.Conn, limits config.WebSocketLimits) {
+	var lastActivity atomic.Int64
+	lastActivity.Store(time.Now().UnixNano())
+
+	// Sin pong dentro de dos intervalos la conexión se da por muerta
+	pongWait := 2 * limits.PingInterval
+
+	for _, conn := range []*fws.Conn{clientConn, serverConn} {
+		if limits.MaxMessageSize > 0 {
+			conn.SetReadLimit(limits.MaxMessageSize)
 		}
-	}()
+		if pongWait > 0 {
+			conn.SetReadDeadline(time.Now().Add(pongWait))
+			conn.SetPongHandler(func(string) error {
+				return conn.SetReadDeadline(time.Now().Add(pongWait))
+			})
+		}
+	}
+
+	results := make(chan pipeResult, 2)
+	go pipeWebSocket(clientConn, serverConn, &session.MessagesFromClient, &session.BytesFromClient, &lastActivity, pongWait, results)
+	go pipeWebSocket(serverConn, clientConn, &session.MessagesFromBackend, &session.BytesFromBackend, &lastActivity, pongWait, results)
+
+	var ping <-chan time.Time
+	if limits.PingInterval > 0 {
+		ticker := time.NewTicker(limits.PingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	var idle <-chan time.Time
+	if limits.IdleTimeout > 0 {
+		ticker := time.NewTicker(min(limits.IdleTimeout, time.Second))
+		defer ticker.Stop()
+		idle = ticker.C
+	}
+
+	var expired <-chan time.Time
+	if limits.MaxSessionDuration > 0 {
+		timer := time.NewTimer(limits.MaxSessionDuration)
+		defer timer.Stop()
+		expired = timer.C
+	}
 
 	for {
 		select {
-		case <-done:
-			return
-		default:
-			messageType, message, err := serverConn.ReadMessage()
-			if err != nil {
-				log.Printf("Error reading message from server: %v", err)
-				return
+		case result := <-results:
+			code, reason := closeFor(result)
+			if result.writing {
+				log.Printf("Error writing WebSocket message: %v", result.err)
+				closeWebSocket(result.src, result.dst, code, reason)
+			} else {
+				result.dst.WriteControl(fws.CloseMessage, fws.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+				result.src.Close()
+				result.dst.Close()
 			}
-			if err := c.WriteMessage(messageType, message); err != nil {
-				log.Printf("Error writing message to customer: %v", err)
+			return
+
+		case <-ping:
+			deadline := time.Now().Add(limits.PingInterval)
+			clientConn.WriteControl(fws.PingMessage, nil, deadline)
+			serverConn.WriteControl(fws.PingMessage, nil, deadline)
+
+		case <-idle:
+			if time.Since(time.Unix(0, lastActivity.Load())) >= limits.IdleTimeout {
+				closeWebSocket(clientConn, serverConn, fws.CloseGoingAway, "idle timeout")
 				return
 			}
+
+		case <-expired:
+			closeWebSocket(clientConn, serverConn, fws.CloseGoingAway, "maximum session duration reached")
+			return
 		}
 	}
 }
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// internalRequestKey marks the requests the proxy makes to itself, such as
+// background cache refreshes. They skip the client checks but go through the
+// same routing and caching as client requests.
+const internalRequestKey = "mixproxy.internal"
+
+func isInternalRequest(c *fiber.Ctx) bool {
+	return c.Locals(internalRequestKey) != nil
+}
+
+// internalApp runs the requests that don't come from a client, such as cache
+// warming. It is the app of a listener that doesn't redirect to HTTPS.
+var internalApp atomic.Pointer[fiber.App]
+
+// waitInternalApp returns internalApp once the listeners are up, nil if they
+// don't start within timeout.
+func waitInternalApp(timeout time.Duration) *fiber.App {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if app := internalApp.Load(); app != nil {
+			return app
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return internalApp.Load()
+}
+
+// doInternalRequest runs req through the handlers of app and returns the
+// status of the response, which is discarded.
+func doInternalRequest(app *fiber.App, req *fasthttp.Request) int {
+	var ctx fasthttp.RequestCtx
+	ctx.Init(req, nil, nil)
+	ctx.SetUserValue(internalRequestKey, true)
+
+	app.Server().Handler(&ctx)
+
+	status := ctx.Response.StatusCode()
+	ctx.Response.ResetBody()
+
+	return status
+}
+
+// doInternalRequestBody is doInternalRequest keeping a copy of the body.
+func doInternalRequestBody(app *fiber.App, req *fasthttp.Request) (int, []byte) {
+	var ctx fasthttp.RequestCtx
+	ctx.Init(req, nil, nil)
+	ctx.SetUserValue(internalRequestKey, true)
+
+	app.Server().Handler(&ctx)
+
+	body, err := ctx.Response.BodyUncompressed()
+	if err != nil {
+		body = nil
+	}
+	body = append([]byte(nil), body...)
+	ctx.Response.ResetBody()
+
+	return ctx.Response.StatusCode(), body
+}
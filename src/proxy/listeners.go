@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"crypto/tls"
+	certificate "mixproxy/src/certs"
+	"mixproxy/src/proxy/config"
+	"mixproxy/src/proxy/tools"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func newTLSConfig() (*tls.Config, error) {
+	tlsConfig, err := cfg.TLS.Apply(&tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certificate.Store.GetCertificate,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		return clientAuthConfig(tlsConfig, hello)
+	}
+
+	return tlsConfig, nil
+}
+
+func listen(l config.ListenerEntry, tlsConfig *tls.Config) (net.Listener, error) {
+	network, address := l.Network()
+
+	if network == "unix" {
+		// Un socket que quedó de una ejecución anterior impide escuchar; solo se
+		// borra si lo que hay en la ruta es un socket
+		if info, err := os.Lstat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.Protocol == "https" {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+
+	return ln, nil
+}
+
+// redirectToHTTPS sends plain HTTP requests to the HTTPS listener on
+// httpsPort, except for subdomains that allow plain HTTP.
+func redirectToHTTPS(httpsPort int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if route, ok := getRoute(getSubdomain(c)); ok && route.AllowHTTP {
+			return c.Next()
+		}
+
+		host := tools.StripPort(string(c.Request().Header.Host()))
+		if httpsPort != 0 && httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+
+		url := "https://" + host + c.OriginalURL()
+		return c.Redirect(url, fiber.StatusMovedPermanently)
+	}
+}
+
+// addHSTS sets Strict-Transport-Security on HTTPS responses of subdomains
+// with hsts configured.
+func addHSTS(c *fiber.Ctx) error {
+	err := c.Next()
+
+	if !c.Context().IsTLS() {
+		return err
+	}
+
+	if route, ok := getRoute(getSubdomain(c)); ok && route.HSTS != nil {
+		c.Set(fiber.HeaderStrictTransportSecurity, route.HSTS.String())
+	}
+
+	return err
+}
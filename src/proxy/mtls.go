@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"mixproxy/src/proxy/config"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type clientAuth struct {
+	pool  *x509.CertPool
+	entry config.MTLSEntry
+}
+
+var (
+	clientAuths   = map[string]*clientAuth{}
+	clientAuthsMu sync.RWMutex
+)
+
+// Headers sent to the backend with the identity of the client certificate.
+// Incoming values are always removed so clients can't forge them.
+var clientCertHeaders = []string{
+	"X-Client-Verify",
+	"X-Client-Cert-Subject",
+	"X-Client-Cert-Issuer",
+	"X-Client-Cert-SAN",
+	"X-Client-Cert-Serial",
+	"X-Client-Cert-Fingerprint",
+}
+
+func setupClientAuths(entries map[string]*config.MTLSEntry) error {
+	auths := map[string]*clientAuth{}
+
+	for subdomain, entry := range entries {
+		if entry == nil {
+			continue
+		}
+
+		pool, err := config.LoadCertPool(entry.CABundle)
+		if err != nil {
+			return err
+		}
+
+		auths[subdomain] = &clientAuth{pool: pool, entry: *entry}
+	}
+
+	clientAuthsMu.Lock()
+	clientAuths = auths
+	clientAuthsMu.Unlock()
+
+	return nil
+}
+
+func getClientAuth(subdomain string) *clientAuth {
+	clientAuthsMu.RLock()
+	defer clientAuthsMu.RUnlock()
+
+	return clientAuths[subdomain]
+}
+
+// clientAuthConfig asks for a client certificate only during handshakes for
+// subdomains with mTLS, so browsers don't get a certificate prompt elsewhere.
+func clientAuthConfig(base *tls.Config, hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	subdomain := ""
+	if hello.ServerName != cfg.Hostname {
+		subdomain = strings.Split(hello.ServerName, ".")[0]
+	}
+
+	if getClientAuth(subdomain) == nil {
+		return nil, nil
+	}
+
+	tlsConfig := base.Clone()
+	tlsConfig.ClientAuth = tls.RequestClientCert
+	tlsConfig.GetConfigForClient = nil
+
+	return tlsConfig, nil
+}
+
+// verifyClientCertificate enforces the mTLS settings of the subdomain and sets
+// the client identity headers for the backend. It returns false when the
+// request has been rejected.
+func verifyClientCertificate(c *fiber.Ctx, subdomain string) bool {
+	for _, h := range clientCertHeaders {
+		c.Request().Header.Del(h)
+	}
+
+	auth := getClientAuth(subdomain)
+	if auth == nil {
+		return true
+	}
+
+	var peers []*x509.Certificate
+	if state := c.Context().TLSConnectionState(); state != nil {
+		peers = state.PeerCertificates
+	}
+
+	if len(peers) == 0 {
+		if auth.entry.Required {
+			c.Status(fiber.StatusForbidden).SendString("A client certificate is required")
+			return false
+		}
+
+		c.Request().Header.Set("X-Client-Verify", "NONE")
+		return true
+	}
+
+	leaf := peers[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range peers[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         auth.pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		c.Status(fiber.StatusForbidden).SendString("Invalid client certificate")
+		return false
+	}
+
+	sans := certificateSANs(leaf)
+
+	if len(auth.entry.AllowedSubjects) != 0 && !matchesAny(auth.entry.AllowedSubjects, leaf.Subject.String(), leaf.Subject.CommonName) {
+		c.Status(fiber.StatusForbidden).SendString("Client certificate not allowed")
+		return false
+	}
+
+	if len(auth.entry.AllowedSANs) != 0 && !matchesAny(auth.entry.AllowedSANs, sans...) {
+		c.Status(fiber.StatusForbidden).SendString("Client certificate not allowed")
+		return false
+	}
+
+	fingerprint := sha256.Sum256(leaf.Raw)
+
+	c.Request().Header.Set("X-Client-Verify", "SUCCESS")
+	c.Request().Header.Set("X-Client-Cert-Subject", leaf.Subject.String())
+	c.Request().Header.Set("X-Client-Cert-Issuer", leaf.Issuer.String())
+	c.Request().Header.Set("X-Client-Cert-SAN", strings.Join(sans, ","))
+	c.Request().Header.Set("X-Client-Cert-Serial", leaf.SerialNumber.Text(16))
+	c.Request().Header.Set("X-Client-Cert-Fingerprint", hex.EncodeToString(fingerprint[:]))
+
+	return true
+}
+
+func certificateSANs(cert *x509.Certificate) []string {
+	sans := append([]string{}, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	return sans
+}
+
+func matchesAny(patterns []string, values ...string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if ok, _ := path.Match(pattern, value); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
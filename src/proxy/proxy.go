@@ -1,31 +1,35 @@
 package proxy
 
 import (
+	"bytes"
+	"crypto/tls"
 	"fmt"
 	"log"
 	api "mixproxy/src/api/admin"
 	certificate "mixproxy/src/certs"
 	"mixproxy/src/proxy/config"
 	"mixproxy/src/redis"
-	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/websocket/v2"
 	"github.com/valyala/fasthttp"
 )
 
 func Stop() {
 	log.Println("Stop server")
 
-	if err := config.SERVERS["HTTP"].Shutdown(); err != nil {
-		log.Println("Error stopping HTTP:", err)
-	}
-	if err := config.SERVERS["HTTPS"].Shutdown(); err != nil {
-		log.Println("Error stopping HTTPS:", err)
+	// Las peticiones internas no deben ir a apps ya paradas
+	internalApp.Store(nil)
+
+	for name, app := range config.Servers() {
+		if err := app.Shutdown(); err != nil {
+			log.Println("Error stopping "+name+":", err)
+		}
 	}
+	config.ClearServers()
 }
 
 func Control(action string) {
@@ -36,6 +40,9 @@ func Control(action string) {
 		Stop()
 	case "reload":
 		reloadConfig()
+		reloadCertificates()
+	case "reloadCertificates":
+		reloadCertificates()
 	case "createCertificates":
 		createCertificates()
 	default:
@@ -44,7 +51,6 @@ func Control(action string) {
 }
 
 func createCertificates() {
-	os.RemoveAll("./certs")
 	cfg, _ := config.ReadConfig()
 
 	host := cfg.Hostname
@@ -57,59 +63,155 @@ func createCertificates() {
 		}
 	}
 
-	certificate.Create(DNSnames)
+	if err := certificate.Create(DNSnames); err != nil {
+		fmt.Println("❌ Error creating certificates:", err)
+	}
+}
+
+func reloadCertificates() {
+	if cfg == nil || !cfg.OnHTTPS {
+		return
+	}
+
+	if err := certificate.Store.Reload(); err != nil {
+		log.Println("❌ Error reloading certificates:", err)
+		return
+	}
+
+	log.Println("🔄 Certificates reloaded")
 }
 
+// Responses are streamed: the body is only read into memory when it is going
+// to be cached, so SSE, chunked APIs and long downloads reach the client as the
+// backend sends them. No read timeout is set so long-lived requests survive.
 var client *fasthttp.Client = &fasthttp.Client{
 	MaxConnsPerHost:     1000,
 	MaxIdleConnDuration: 90 * time.Second,
+	StreamResponseBody:  true,
 }
 
-func startHttpAndHttpsServer(wg *sync.WaitGroup) {
-	defer wg.Done()
+func registerProxyRoutes(app *fiber.App) {
+	app.Use(addHSTS)
+	api.HandleAdminAPI(app)
 
-	api.HandleAdminAPI()
-
-	// Configurar proxy reverso para HTTPS y WSS
-	config.SERVERS["HTTPS"].All("/*", func(c *fiber.Ctx) error {
+	// Configurar proxy reverso para HTTP(S) y WS(S)
+	app.All("/*", func(c *fiber.Ctx) error {
 		return handleHTTPS(c)
 	})
+}
 
-	config.SERVERS["HTTPS"].Use("/*", websocket.New(func(c *websocket.Conn) {
-		handleWebSocket(c)
-	}))
+func startHttpAndHttpsServer(wg *sync.WaitGroup) {
+	defer wg.Done()
 
-	// Redirigir todas las peticiones HTTP a HTTPS
-	config.SERVERS["HTTP"].All("/*", func(c *fiber.Ctx) error {
-		host := string(c.Request().Header.Host())
-		url := "https://" + host + c.OriginalURL()
-		return c.Redirect(url, fiber.StatusMovedPermanently)
-	})
+	listeners := cfg.GetListeners()
 
-	// Iniciar servidor HTTPS en puerto 443 con certificados wildcard
-	go func() {
-		log.Println("✅ Servidor HTTPS iniciado en puerto 443")
-		crt, key := getCertificateConfig()
+	hasHTTPS := false
+	httpsPort := 0
+	for _, l := range listeners {
+		if l.Protocol == "https" {
+			hasHTTPS = true
+			httpsPort = l.Port
+			break
+		}
+	}
 
-		if err := config.SERVERS["HTTPS"].ListenTLS(":443", crt, key); err != nil {
+	var tlsConfig *tls.Config
+	if hasHTTPS {
+		// Los certificados se leen en cada handshake, así que renovarlos no requiere reiniciar
+		if err := certificate.Store.Reload(); err != nil {
 			log.Fatalf("❌ Error HTTPS: %v", err)
 		}
-	}()
+		certificate.Store.Watch(30 * time.Second)
 
-	// Iniciar servidor HTTP en puerto 80 (redirige a HTTPS)
-	log.Println("🔄 Servidor HTTP iniciado en puerto 80 (redirige a HTTPS)")
-	if err := config.SERVERS["HTTP"].Listen(":80"); err != nil {
-		log.Fatalf("❌ Error HTTP: %v", err)
+		var err error
+		if tlsConfig, err = newTLSConfig(); err != nil {
+			log.Fatalf("❌ Error HTTPS: %v", err)
+		}
 	}
 
+	serversDone := &sync.WaitGroup{}
+	internalApp.Store(nil)
+
+	for _, l := range listeners {
+		app := fiber.New(fiber.Config{
+			DisableStartupMessage: true,
+			RequestMethods:        append(append([]string{}, fiber.DefaultMethods...), methodPurge),
+		})
+		if l.RedirectToHTTPS {
+			app.Use(redirectToHTTPS(httpsPort))
+		}
+		registerProxyRoutes(app)
+		config.SetServer(l.String(), app)
+		if !l.RedirectToHTTPS {
+			internalApp.CompareAndSwap(nil, app)
+		}
+
+		ln, err := listen(l, tlsConfig)
+		if err != nil {
+			log.Fatalf("❌ Error %s: %v", l, err)
+		}
+
+		if l.RedirectToHTTPS {
+			log.Printf("🔄 Servidor iniciado en %s (redirige a HTTPS)", l)
+		} else {
+			log.Printf("✅ Servidor iniciado en %s", l)
+		}
+
+		serversDone.Add(1)
+		go func() {
+			defer serversDone.Done()
+
+			if err := app.Listener(ln); err != nil {
+				log.Fatalf("❌ Error %s: %v", l, err)
+			}
+		}()
+	}
+
+	serversDone.Wait()
 }
 
 func generateCacheKey(c *fiber.Ctx) string {
-	// Key: method:url/path:accept
-	accept := c.Get("Accept")
-	_, host := getSubdomainAndHost(c)
+	// Key: subdomain:method:url/path; the headers in Vary pick the variant.
+	// HEAD is answered from the GET entries
+	method := c.Method()
+	if method == fiber.MethodHead {
+		method = fiber.MethodGet
+	}
+	return redis.CacheKey(getSubdomain(c), method, cacheKeyURI(c))
+}
 
-	return c.Method() + ":" + host + c.OriginalURL() + ":" + accept
+// cacheKeyURI returns the URI used in the cache key, with the query
+// parameters kept or dropped by the matching cache rule, sorted.
+func cacheKeyURI(c *fiber.Ctx) string {
+	rule := getCacheRule(getSubdomain(c), c.Path())
+	if rule == nil || (len(rule.QueryParams) == 0 && len(rule.IgnoreQueryParams) == 0) {
+		return c.OriginalURL()
+	}
+
+	path, _, _ := strings.Cut(c.OriginalURL(), "?")
+	if slices.Contains(rule.IgnoreQueryParams, "*") {
+		return path
+	}
+
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+
+	c.Request().URI().QueryArgs().VisitAll(func(key, value []byte) {
+		name := string(key)
+		if len(rule.QueryParams) != 0 && !slices.Contains(rule.QueryParams, name) {
+			return
+		}
+		if slices.Contains(rule.IgnoreQueryParams, name) {
+			return
+		}
+		args.AddBytesKV(key, value)
+	})
+	if args.Len() == 0 {
+		return path
+	}
+	args.Sort(bytes.Compare)
+
+	return path + "?" + args.String()
 }
 
 func pathMatches(pattern, path string) bool {
@@ -125,6 +227,33 @@ func pathMatches(pattern, path string) bool {
 }
 
 func isCacheable(c *fiber.Ctx) bool {
+	if !cachesPath(c) {
+		return false
+	}
+
+	// Don't cache errors, unless a rule gives their status a TTL
+	if status := c.Response().StatusCode(); status >= 400 {
+		rule := getCacheRule(getSubdomain(c), c.Path())
+		if rule == nil {
+			return false
+		}
+		if _, ok := rule.ResponseTTL(status); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// cacheBypassed reports whether a cache rule excludes the requested path.
+func cacheBypassed(c *fiber.Ctx, subdomain string) bool {
+	rule := getCacheRule(subdomain, c.Path())
+	return rule != nil && rule.Bypass
+}
+
+// cachesPath reports whether the subdomain has the cache enabled for the
+// requested path, regardless of the response.
+func cachesPath(c *fiber.Ctx) bool {
 	subdomain := getSubdomain(c)
 	path := c.OriginalURL()
 
@@ -133,6 +262,11 @@ func isCacheable(c *fiber.Ctx) bool {
 		return false
 	}
 
+	// Las reglas tienen prioridad sobre cache_paths
+	if rule := getCacheRule(subdomain, c.Path()); rule != nil {
+		return !rule.Bypass
+	}
+
 	// Get cache paths for subdomain
 	paths, err := redis.GetCachePaths(subdomain)
 	if err != nil {
@@ -147,27 +281,13 @@ func isCacheable(c *fiber.Ctx) bool {
 		}
 	}
 
-	if !pathMatchesConfig {
-		return false
-	}
-
-	// Check request Cache-Control
-	cc := c.Get("Cache-Control")
-	if strings.Contains(cc, "no-cache") || strings.Contains(cc, "private") {
-		return false
-	}
-
-	// Don't cache errors
-	if c.Response().StatusCode() >= 400 {
-		return false
-	}
-
-	return true
+	return pathMatchesConfig
 }
 
 func Start() {
 	log.Println("Start server")
 	api.SetControlFunc(Control)
+	api.SetWarmFunc(startWarming)
 
 	reloadConfig()
 
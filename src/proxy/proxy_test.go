@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"mixproxy/src/proxy/config"
+	"testing"
+)
+
+func TestCacheKeyURI(t *testing.T) {
+	setupRoutes(map[string]config.LoadBalancerEntry{
+		"app": {Subdomain: "app", Cache: &config.CacheEntry{Rules: []config.CacheRuleEntry{
+			{Path: "/search/*", QueryParams: []string{"q", "page"}},
+			{Path: "/blog/*", IgnoreQueryParams: []string{"utm_source", "utm_medium"}},
+			{Path: "/static/*", IgnoreQueryParams: []string{"*"}},
+			{Path: "/*", TTL: "1m"},
+		}}},
+	})
+	t.Cleanup(func() { setupRoutes(nil) })
+
+	tests := []struct {
+		name string
+		host string
+		uri  string
+		want string
+	}{
+		{"rule without query handling", "app.dev.space", "/page?b=2&a=1", "/page?b=2&a=1"},
+		{"subdomain without rules", "other.dev.space", "/search/?q=go&x=1", "/search/?q=go&x=1"},
+		{"keeps query_params, sorted", "app.dev.space", "/search/?x=1&q=go&page=2", "/search/?page=2&q=go"},
+		{"no query_params left", "app.dev.space", "/search/?x=1", "/search/"},
+		{"repeated parameters", "app.dev.space", "/search/?q=b&q=a", "/search/?q=a&q=b"},
+		{"drops ignore_query_params", "app.dev.space", "/blog/post?utm_source=x&id=7&utm_medium=y", "/blog/post?id=7"},
+		{"drops the whole query", "app.dev.space", "/static/app.js?v=123", "/static/app.js"},
+		{"without query", "app.dev.space", "/blog/post", "/blog/post"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCtx(t, tt.host, tt.uri)
+			if got := cacheKeyURI(c); got != tt.want {
+				t.Errorf("cacheKeyURI(%q) = %q, want %q", tt.uri, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateCacheKey(t *testing.T) {
+	setupRoutes(nil)
+
+	tests := []struct {
+		method string
+		want   string
+	}{
+		{"GET", "cache:app:GET:/page?a=1"},
+		{"HEAD", "cache:app:GET:/page?a=1"},
+		{"POST", "cache:app:POST:/page?a=1"},
+	}
+
+	for _, tt := range tests {
+		c := newTestCtx(t, "app.dev.space", "/page?a=1")
+		c.Method(tt.method)
+		if got := generateCacheKey(c); got != tt.want {
+			t.Errorf("%s key = %q, want %q", tt.method, got, tt.want)
+		}
+	}
+}
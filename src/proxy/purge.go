@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"log"
+	"mixproxy/src/redis"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const methodPurge = "PURGE"
+
+// handlePurge drops a cached URL with "PURGE /path" when the route enables
+// allow_purge and the client IP is on the subdomain whitelist.
+func handlePurge(c *fiber.Ctx, subdomain string) error {
+	route, _ := getRoute(subdomain)
+	if route.Cache == nil || !route.Cache.AllowPurge {
+		return c.Status(fiber.StatusMethodNotAllowed).SendString("Method Not Allowed")
+	}
+
+	if _, err := redis.GetIPForWhitelist(subdomain, c.IP()); err != nil {
+		return c.Status(fiber.StatusForbidden).SendString("You are not on the whitelist")
+	}
+
+	purged, err := redis.PurgeURL(subdomain, cacheKeyURI(c))
+	if err != nil {
+		log.Printf("Error purging %s: %v", c.OriginalURL(), err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"status": "ok", "purged": purged})
+}
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"log"
+	"mixproxy/src/proxy/config"
+	"sync"
+)
+
+// routes keeps the load balancer entry of every subdomain ("" for the root)
+// so handlers can read per-route settings without going to Redis. The cache
+// rules are compiled once here.
+var (
+	routes     = map[string]config.LoadBalancerEntry{}
+	cacheRules = map[string][]config.CacheRule{}
+	routesMu   sync.RWMutex
+)
+
+func setupRoutes(entries map[string]config.LoadBalancerEntry) {
+	rules := map[string][]config.CacheRule{}
+	for subdomain, entry := range entries {
+		compiled, err := entry.Cache.CacheRules()
+		if err != nil {
+			log.Printf("Invalid cache rules for subdomain '%s': %v", subdomain, err)
+			continue
+		}
+		rules[subdomain] = compiled
+	}
+
+	routesMu.Lock()
+	routes = entries
+	cacheRules = rules
+	routesMu.Unlock()
+}
+
+func getRoute(subdomain string) (config.LoadBalancerEntry, bool) {
+	routesMu.RLock()
+	defer routesMu.RUnlock()
+
+	route, ok := routes[subdomain]
+	return route, ok
+}
+
+// getCacheRule returns the first cache rule of the subdomain matching the
+// request path, or nil.
+func getCacheRule(subdomain, path string) *config.CacheRule {
+	routesMu.RLock()
+	defer routesMu.RUnlock()
+
+	for i := range cacheRules[subdomain] {
+		if cacheRules[subdomain][i].Matches(path) {
+			return &cacheRules[subdomain][i]
+		}
+	}
+
+	return nil
+}
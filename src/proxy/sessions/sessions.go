@@ -0,0 +1,150 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Session is a live WebSocket tunnel between a client and a backend.
+type Session struct {
+	ID        string
+	ClientIP  string
+	Subdomain string
+	Backend   string
+	Path      string
+	StartedAt time.Time
+
+	BytesFromClient     atomic.Int64
+	BytesFromBackend    atomic.Int64
+	MessagesFromClient  atomic.Int64
+	MessagesFromBackend atomic.Int64
+
+	close     func()
+	closeOnce sync.Once
+}
+
+// Info is the JSON view of a session.
+type Info struct {
+	ID                  string    `json:"id"`
+	ClientIP            string    `json:"client_ip"`
+	Subdomain           string    `json:"subdomain"`
+	Backend             string    `json:"backend"`
+	Path                string    `json:"path"`
+	StartedAt           time.Time `json:"started_at"`
+	BytesFromClient     int64     `json:"bytes_from_client"`
+	BytesFromBackend    int64     `json:"bytes_from_backend"`
+	MessagesFromClient  int64     `json:"messages_from_client"`
+	MessagesFromBackend int64     `json:"messages_from_backend"`
+}
+
+var (
+	active   = map[string]*Session{}
+	activeMu sync.RWMutex
+)
+
+// Add registers a session; close is called when an administrator closes it.
+func Add(clientIP, subdomain, backend, path string, close func()) *Session {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	s := &Session{
+		ID:        hex.EncodeToString(id),
+		ClientIP:  clientIP,
+		Subdomain: subdomain,
+		Backend:   backend,
+		Path:      path,
+		StartedAt: time.Now(),
+		close:     close,
+	}
+
+	activeMu.Lock()
+	active[s.ID] = s
+	activeMu.Unlock()
+
+	return s
+}
+
+func Remove(s *Session) {
+	activeMu.Lock()
+	delete(active, s.ID)
+	activeMu.Unlock()
+}
+
+func (s *Session) Info() Info {
+	return Info{
+		ID:                  s.ID,
+		ClientIP:            s.ClientIP,
+		Subdomain:           s.Subdomain,
+		Backend:             s.Backend,
+		Path:                s.Path,
+		StartedAt:           s.StartedAt,
+		BytesFromClient:     s.BytesFromClient.Load(),
+		BytesFromBackend:    s.BytesFromBackend.Load(),
+		MessagesFromClient:  s.MessagesFromClient.Load(),
+		MessagesFromBackend: s.MessagesFromBackend.Load(),
+	}
+}
+
+// Close ends the tunnel; calling it more than once is harmless.
+func (s *Session) Close() {
+	s.closeOnce.Do(s.close)
+}
+
+func List() []Info {
+	activeMu.RLock()
+	defer activeMu.RUnlock()
+
+	infos := []Info{}
+	for _, s := range active {
+		infos = append(infos, s.Info())
+	}
+
+	slices.SortFunc(infos, func(a, b Info) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+
+	return infos
+}
+
+func Count() int {
+	activeMu.RLock()
+	defer activeMu.RUnlock()
+
+	return len(active)
+}
+
+// CloseByID closes a session and reports whether it existed.
+func CloseByID(id string) bool {
+	activeMu.RLock()
+	s, ok := active[id]
+	activeMu.RUnlock()
+
+	if ok {
+		s.Close()
+	}
+
+	return ok
+}
+
+// CloseSubdomain closes every session of a subdomain and returns how many
+// were closed.
+func CloseSubdomain(subdomain string) int {
+	activeMu.RLock()
+	matched := []*Session{}
+	for _, s := range active {
+		if s.Subdomain == subdomain {
+			matched = append(matched, s)
+		}
+	}
+	activeMu.RUnlock()
+
+	for _, s := range matched {
+		s.Close()
+	}
+
+	return len(matched)
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// isStreamingRequest reports whether the request goes to a path configured in
+// stream_paths or asks for Server-Sent Events. Those requests skip the cache.
+func isStreamingRequest(c *fiber.Ctx, subdomain string) bool {
+	if strings.Contains(c.Get(fiber.HeaderAccept), "text/event-stream") {
+		return true
+	}
+
+	route, ok := getRoute(subdomain)
+	if !ok {
+		return false
+	}
+
+	for _, p := range route.StreamPaths {
+		if pathMatches(p, c.Path()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isStreamingResponse reports whether the upstream body must be passed to the
+// client as it arrives instead of being read for the cache.
+func isStreamingResponse(c *fiber.Ctx, subdomain string) bool {
+	contentType := string(c.Response().Header.ContentType())
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		return true
+	}
+
+	return isStreamingRequest(c, subdomain)
+}
+
+// readUpstreamBody reads the upstream body into memory when its length is
+// known and at most limit bytes; otherwise it keeps streaming to the client
+// and ok is false. ok is also false when the read fails, as fasthttp then
+// replaces the body with the error message.
+func readUpstreamBody(c *fiber.Ctx, limit int64) ([]byte, bool) {
+	if !c.Response().IsBodyStream() {
+		return c.Response().Body(), true
+	}
+
+	length := c.Response().Header.ContentLength()
+	if length < 0 || int64(length) > limit {
+		return nil, false
+	}
+
+	body := c.Response().Body()
+	if len(body) != length {
+		return nil, false
+	}
+
+	return body, true
+}
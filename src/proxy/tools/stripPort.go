@@ -0,0 +1,11 @@
+package tools
+
+import "net"
+
+// StripPort removes the port from a host such as "example.com:8080".
+func StripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"mixproxy/src/proxy/config"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Each backend with its own TLS settings gets a dedicated client; the rest
+// share the default client.
+var (
+	upstreamClients = map[string]*fasthttp.Client{}
+	upstreamTLS     = map[string]*tls.Config{}
+	upstreamMu      sync.RWMutex
+)
+
+func setupUpstreams(backends map[string]*config.UpstreamTLSEntry) error {
+	clients := map[string]*fasthttp.Client{}
+	tlsConfigs := map[string]*tls.Config{}
+
+	for backend, entry := range backends {
+		if entry == nil {
+			continue
+		}
+
+		tlsConfig, err := config.LoadUpstreamTLS(entry)
+		if err != nil {
+			return err
+		}
+
+		tlsConfigs[backend] = tlsConfig
+		clients[backend] = &fasthttp.Client{
+			MaxConnsPerHost:     1000,
+			MaxIdleConnDuration: 90 * time.Second,
+			TLSConfig:           tlsConfig,
+			StreamResponseBody:  true,
+		}
+	}
+
+	upstreamMu.Lock()
+	upstreamClients = clients
+	upstreamTLS = tlsConfigs
+	upstreamMu.Unlock()
+
+	return nil
+}
+
+func getUpstreamClient(backend string) *fasthttp.Client {
+	upstreamMu.RLock()
+	defer upstreamMu.RUnlock()
+
+	if c, ok := upstreamClients[backend]; ok {
+		return c
+	}
+
+	return client
+}
+
+func getUpstreamTLSConfig(backend string) *tls.Config {
+	upstreamMu.RLock()
+	defer upstreamMu.RUnlock()
+
+	if tlsConfig, ok := upstreamTLS[backend]; ok {
+		return tlsConfig.Clone()
+	}
+
+	return &tls.Config{MinVersion: tls.VersionTLS12}
+}
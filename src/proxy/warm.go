@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"mixproxy/src/proxy/config"
+	"mixproxy/src/proxy/warming"
+	neturl "net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// maxWarmURLs bounds the URLs taken from sitemaps, the most a sitemap may
+// list.
+const maxWarmURLs = 50000
+
+// warmAppTimeout is how long warming waits for the listeners to start, as
+// the first reload runs before them.
+const warmAppTimeout = 30 * time.Second
+
+// sitemap reads both a <urlset> and a <sitemapindex>.
+type sitemap struct {
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// startWarming requests the configured URLs of the subdomain through the
+// proxy so they get cached. urls and sitemapURL replace the configured ones
+// when set. The job runs in the background; its progress is in warming.
+func startWarming(subdomain string, urls []string, sitemapURL string) error {
+	route, ok := getRoute(subdomain)
+	if !ok {
+		return fmt.Errorf("subdomain '%s' not found", subdomain)
+	}
+
+	// Las URLs de la API no pasan por ValidateConfig
+	if err := config.ValidateWarmURLs(urls); err != nil {
+		return err
+	}
+
+	var entry *config.CacheWarmEntry
+	if route.Cache != nil {
+		entry = route.Cache.Warm
+	}
+
+	if len(urls) == 0 && sitemapURL == "" && entry != nil {
+		urls = entry.URLs
+		sitemapURL = entry.Sitemap
+	}
+	if len(urls) == 0 && sitemapURL == "" {
+		return fmt.Errorf("no urls or sitemap to warm the cache of subdomain '%s'", subdomain)
+	}
+
+	job, ok := warming.Start(subdomain)
+	if !ok {
+		return fmt.Errorf("the cache of subdomain '%s' is already being warmed", subdomain)
+	}
+
+	go func() {
+		err := warm(job, subdomain, urls, sitemapURL, entry.WarmConcurrency())
+		if err != nil {
+			log.Printf("❌ Error warming the cache of subdomain '%s': %v", subdomain, err)
+		}
+		job.Finish(err)
+	}()
+
+	return nil
+}
+
+func warm(job *warming.Job, subdomain string, urls []string, sitemapURL string, concurrency int) error {
+	app := waitInternalApp(warmAppTimeout)
+	if app == nil {
+		return fmt.Errorf("no listener to send the requests to")
+	}
+
+	host := cfg.Hostname
+	if subdomain != "" {
+		host = subdomain + "." + cfg.Hostname
+	}
+
+	if sitemapURL != "" {
+		found, err := readSitemap(app, host, sitemapURL, 1)
+		if err != nil {
+			return err
+		}
+		urls = append(slices.Clone(urls), found...)
+	}
+	job.SetTotal(len(urls))
+
+	// Como mucho concurrency peticiones a la vez
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+	for _, uri := range urls {
+		sem <- struct{}{}
+		wg.Add(1)
+
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			status := doInternalRequest(app, warmRequest(host, uri))
+			if status >= fiber.StatusBadRequest {
+				job.Done(fmt.Errorf("%s answered %d", uri, status))
+				return
+			}
+			job.Done(nil)
+		}()
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func warmRequest(host, uri string) *fasthttp.Request {
+	req := &fasthttp.Request{}
+	req.Header.SetMethod(fiber.MethodGet)
+	req.Header.SetHost(host)
+	req.SetRequestURI(uri)
+
+	return req
+}
+
+// readSitemap returns the request URIs listed by a sitemap of the subdomain,
+// following a sitemap index up to depth levels.
+func readSitemap(app *fiber.App, host, sitemapURL string, depth int) ([]string, error) {
+	uri, ok := sameHostURI(host, sitemapURL)
+	if !ok {
+		return nil, fmt.Errorf("sitemap '%s' isn't on %s", sitemapURL, host)
+	}
+
+	status, body := doInternalRequestBody(app, warmRequest(host, uri))
+	if status != fiber.StatusOK {
+		return nil, fmt.Errorf("sitemap '%s' answered %d", sitemapURL, status)
+	}
+
+	var s sitemap
+	if err := xml.Unmarshal(body, &s); err != nil {
+		return nil, fmt.Errorf("invalid sitemap '%s': %w", sitemapURL, err)
+	}
+
+	urls := []string{}
+	for _, u := range s.URLs {
+		// Las URLs de otros hosts no pasan por esta caché
+		if uri, ok := sameHostURI(host, u.Loc); ok && len(urls) < maxWarmURLs {
+			urls = append(urls, uri)
+		}
+	}
+
+	if depth > 0 {
+		for _, nested := range s.Sitemaps {
+			found, err := readSitemap(app, host, nested.Loc, depth-1)
+			if err != nil {
+				log.Printf("❌ Error reading sitemap: %v", err)
+				continue
+			}
+			urls = append(urls, found[:min(len(found), maxWarmURLs-len(urls))]...)
+		}
+	}
+
+	return urls, nil
+}
+
+// sameHostURI returns the request URI of a path or of a full URL on host.
+func sameHostURI(host, rawURL string) (string, bool) {
+	parsed, err := neturl.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	if parsed.Host != "" && parsed.Hostname() != host {
+		return "", false
+	}
+	if parsed.Host == "" && !strings.HasPrefix(parsed.Path, "/") {
+		return "", false
+	}
+
+	return parsed.RequestURI(), true
+}
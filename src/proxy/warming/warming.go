@@ -0,0 +1,112 @@
+package warming
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Job is a run of the cache warming of a subdomain. Only the last job of
+// each subdomain is kept.
+type Job struct {
+	Subdomain  string
+	StartedAt  time.Time
+	finishedAt atomic.Pointer[time.Time]
+
+	total     atomic.Int64
+	done      atomic.Int64
+	failed    atomic.Int64
+	lastError atomic.Value
+}
+
+// Info is the JSON view of a job.
+type Info struct {
+	Subdomain  string     `json:"subdomain"`
+	Running    bool       `json:"running"`
+	Total      int64      `json:"total"`
+	Done       int64      `json:"done"`
+	Failed     int64      `json:"failed"`
+	LastError  string     `json:"last_error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+var (
+	jobs   = map[string]*Job{}
+	jobsMu sync.Mutex
+)
+
+// Start registers a new job for the subdomain; ok is false while another one
+// is running.
+func Start(subdomain string) (*Job, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	if j, ok := jobs[subdomain]; ok && j.Running() {
+		return j, false
+	}
+
+	j := &Job{Subdomain: subdomain, StartedAt: time.Now()}
+	jobs[subdomain] = j
+
+	return j, true
+}
+
+// SetTotal sets how many URLs the job requests, once they are known.
+func (j *Job) SetTotal(total int) {
+	j.total.Store(int64(total))
+}
+
+// Done counts a requested URL; err is nil when it was answered.
+func (j *Job) Done(err error) {
+	j.done.Add(1)
+	if err != nil {
+		j.failed.Add(1)
+		j.lastError.Store(err.Error())
+	}
+}
+
+// Finish ends the job; err is set when it couldn't even start, e.g. the
+// sitemap couldn't be read.
+func (j *Job) Finish(err error) {
+	if err != nil {
+		j.lastError.Store(err.Error())
+	}
+	now := time.Now()
+	j.finishedAt.Store(&now)
+}
+
+func (j *Job) Running() bool {
+	return j.finishedAt.Load() == nil
+}
+
+func (j *Job) Info() Info {
+	info := Info{
+		Subdomain:  j.Subdomain,
+		Running:    j.Running(),
+		Total:      j.total.Load(),
+		Done:       j.done.Load(),
+		Failed:     j.failed.Load(),
+		StartedAt:  j.StartedAt,
+		FinishedAt: j.finishedAt.Load(),
+	}
+	if lastError, ok := j.lastError.Load().(string); ok {
+		info.LastError = lastError
+	}
+
+	return info
+}
+
+// List returns the last job of every subdomain, sorted by subdomain.
+func List() []Info {
+	jobsMu.Lock()
+	list := make([]Info, 0, len(jobs))
+	for _, j := range jobs {
+		list = append(list, j.Info())
+	}
+	jobsMu.Unlock()
+
+	sort.Slice(list, func(i, k int) bool { return list[i].Subdomain < list[k].Subdomain })
+	return list
+}
@@ -13,10 +13,46 @@ var rdb *rd.Client
 var rdbWhitelist *rd.Client
 var rdbBlacklist *rd.Client
 
+// Header is a response header; headers with several values appear once per
+// value.
+type Header struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
 type CachedResponse struct {
-	Status  int               `json:"status"`
-	Headers map[string]string `json:"headers"`
-	Body    string            `json:"body"`
+	Status  int      `json:"status"`
+	Headers []Header `json:"headers"`
+	Body    []byte   `json:"body"`
+	// Created is when the response was generated upstream, so the Age header
+	// keeps counting; Expires is when it stops being fresh.
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	// Vary lists the request headers the response depends on. An entry with
+	// Vary and no Status is the index of a URL whose variants are stored
+	// under secondary keys.
+	Vary []string `json:"vary,omitempty"`
+	// Validators used to answer conditional requests and to revalidate the
+	// response with the backend once it is stale.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// Until when the response may be served stale while it is refreshed in
+	// the background, and when the backend fails.
+	StaleWhileRevalidate time.Time `json:"stale_while_revalidate"`
+	StaleIfError         time.Time `json:"stale_if_error"`
+	// Tags from Surrogate-Key and Cache-Tag, kept across revalidations.
+	Tags []string `json:"tags,omitempty"`
+
+	// Key is where the response was read from or written to; it isn't stored.
+	Key string `json:"-"`
+}
+
+func (r CachedResponse) IsFresh() bool {
+	return time.Now().Before(r.Expires)
+}
+
+func (r CachedResponse) HasValidators() bool {
+	return r.ETag != "" || r.LastModified != ""
 }
 
 func init() {
@@ -63,27 +99,87 @@ func IsTheIPBlocked(ip string) (bool, error) {
 	return result, nil
 }
 
-func SetCachedResponse(key string, resp CachedResponse, ttl time.Duration) error {
-	data, err := json.Marshal(resp)
-	if err != nil {
+// SetCachedResponse stores resp in its binary format, deflating the body when
+// compress is set.
+func SetCachedResponse(key string, resp CachedResponse, ttl time.Duration, compress bool) error {
+	data := encodeCachedResponse(resp, compress)
+	if err := rdb.Set(ctx, key, data, ttl).Err(); err != nil {
 		return err
 	}
-	return rdb.Set(ctx, key, data, ttl).Err()
+	if err := recordCacheUsage(key, len(data), ttl); err != nil {
+		return err
+	}
+
+	if l1.enabled() {
+		l1.set(key, resp, ttl)
+		publishCacheInvalidation(key)
+	}
+	return nil
+}
+
+// CacheTTL returns how long the key has left in the cache, 0 if it doesn't
+// exist or never expires.
+func CacheTTL(key string) time.Duration {
+	ttl, err := rdb.PTTL(ctx, key).Result()
+	if err != nil || ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// LockCacheRefresh makes sure a single background refresh per key runs at a
+// time, across every proxy sharing the Redis instance.
+func LockCacheRefresh(key string, ttl time.Duration) bool {
+	ok, err := rdb.SetNX(ctx, "cache_refresh:"+key, true, ttl).Result()
+	return err == nil && ok
+}
+
+func UnlockCacheRefresh(key string) {
+	rdb.Del(ctx, "cache_refresh:"+key)
 }
 
 func GetCachedResponse(key string) (CachedResponse, bool, error) {
-	data, err := rdb.Get(ctx, key).Result()
+	if !l1.enabled() {
+		data, err := rdb.Get(ctx, key).Bytes()
+		if err == rd.Nil {
+			return CachedResponse{}, false, nil
+		}
+		if err != nil {
+			return CachedResponse{}, false, err
+		}
+		resp, err := decodeCachedResponse(data)
+		if err != nil {
+			return CachedResponse{}, false, err
+		}
+		return resp, true, nil
+	}
+
+	if resp, ok := l1.get(key); ok {
+		return resp, true, nil
+	}
+
+	// El TTL se pide a la vez para que la copia en memoria caduque con Redis
+	var get *rd.StringCmd
+	var pttl *rd.DurationCmd
+	_, err := rdb.Pipelined(ctx, func(pipe rd.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		pttl = pipe.PTTL(ctx, key)
+		return nil
+	})
 	if err == rd.Nil {
 		return CachedResponse{}, false, nil
 	}
 	if err != nil {
 		return CachedResponse{}, false, err
 	}
-	var resp CachedResponse
-	err = json.Unmarshal([]byte(data), &resp)
+
+	data, _ := get.Bytes()
+	resp, err := decodeCachedResponse(data)
 	if err != nil {
 		return CachedResponse{}, false, err
 	}
+	l1.set(key, resp, pttl.Val())
+
 	return resp, true, nil
 }
 
@@ -129,4 +225,7 @@ func Clean() {
 	if err != nil {
 		panic(err)
 	}
+
+	l1.flush()
+	publishCacheInvalidation()
 }
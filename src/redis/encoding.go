@@ -0,0 +1,208 @@
+package redis
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// Cached responses are stored as a version byte, a flags byte and then the
+// fields in a fixed order: integers as uvarints, strings and bytes prefixed
+// with their length, times as Unix nanoseconds (0 for the zero time). With
+// flagCompressedBody the body is deflate-compressed.
+const cacheFormatVersion = 1
+
+const flagCompressedBody = 1
+
+// Bodies smaller than this aren't worth compressing.
+const minCompressedBodySize = 1024
+
+var errCacheFormat = errors.New("invalid cached response")
+
+type cacheEncoder struct {
+	buf []byte
+}
+
+func (e *cacheEncoder) uint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *cacheEncoder) bytes(b []byte) {
+	e.uint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *cacheEncoder) string(s string) {
+	e.uint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *cacheEncoder) strings(values []string) {
+	e.uint(uint64(len(values)))
+	for _, v := range values {
+		e.string(v)
+	}
+}
+
+func (e *cacheEncoder) time(t time.Time) {
+	if t.IsZero() {
+		e.uint(0)
+		return
+	}
+	e.uint(uint64(t.UnixNano()))
+}
+
+type cacheDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *cacheDecoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errCacheFormat
+		return 0
+	}
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+func (d *cacheDecoder) bytes() []byte {
+	n := d.uint()
+	if d.err != nil || n > uint64(len(d.buf)) {
+		d.err = errCacheFormat
+		return nil
+	}
+
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+
+	return b
+}
+
+func (d *cacheDecoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *cacheDecoder) strings() []string {
+	n := d.uint()
+	if d.err != nil || n > uint64(len(d.buf)) {
+		d.err = errCacheFormat
+		return nil
+	}
+
+	var values []string
+	for i := uint64(0); i < n; i++ {
+		values = append(values, d.string())
+	}
+
+	return values
+}
+
+func (d *cacheDecoder) time() time.Time {
+	v := d.uint()
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(v))
+}
+
+func encodeCachedResponse(resp CachedResponse, compress bool) []byte {
+	body := resp.Body
+	flags := byte(0)
+
+	if compress && len(body) >= minCompressedBodySize {
+		if compressed, err := deflate(body); err == nil && len(compressed) < len(body) {
+			body = compressed
+			flags |= flagCompressedBody
+		}
+	}
+
+	e := &cacheEncoder{buf: make([]byte, 0, len(body)+512)}
+	e.buf = append(e.buf, cacheFormatVersion, flags)
+
+	e.uint(uint64(resp.Status))
+	e.uint(uint64(len(resp.Headers)))
+	for _, h := range resp.Headers {
+		e.string(h.Key)
+		e.string(h.Value)
+	}
+	e.bytes(body)
+	e.time(resp.Created)
+	e.time(resp.Expires)
+	e.strings(resp.Vary)
+	e.string(resp.ETag)
+	e.string(resp.LastModified)
+	e.time(resp.StaleWhileRevalidate)
+	e.time(resp.StaleIfError)
+	e.strings(resp.Tags)
+
+	return e.buf
+}
+
+func decodeCachedResponse(data []byte) (CachedResponse, error) {
+	var resp CachedResponse
+
+	if len(data) < 2 || data[0] != cacheFormatVersion {
+		return resp, errCacheFormat
+	}
+	flags := data[1]
+	d := &cacheDecoder{buf: data[2:]}
+
+	resp.Status = int(d.uint())
+	headers := d.uint()
+	if headers > uint64(len(d.buf)) {
+		return resp, errCacheFormat
+	}
+	for i := uint64(0); i < headers; i++ {
+		resp.Headers = append(resp.Headers, Header{Key: d.string(), Value: d.string()})
+	}
+	resp.Body = d.bytes()
+	resp.Created = d.time()
+	resp.Expires = d.time()
+	resp.Vary = d.strings()
+	resp.ETag = d.string()
+	resp.LastModified = d.string()
+	resp.StaleWhileRevalidate = d.time()
+	resp.StaleIfError = d.time()
+	resp.Tags = d.strings()
+
+	if d.err != nil {
+		return resp, d.err
+	}
+
+	if flags&flagCompressedBody != 0 {
+		body, err := io.ReadAll(flate.NewReader(bytes.NewReader(resp.Body)))
+		if err != nil {
+			return resp, err
+		}
+		resp.Body = body
+	}
+
+	return resp, nil
+}
+
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,149 @@
+package redis
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCachedResponseRoundTrip(t *testing.T) {
+	now := time.Unix(1760000000, 123456789)
+
+	binary := make([]byte, 256)
+	for i := range binary {
+		binary[i] = byte(i)
+	}
+
+	tests := []struct {
+		name     string
+		resp     CachedResponse
+		compress bool
+	}{
+		{
+			name: "empty",
+			resp: CachedResponse{Status: 204},
+		},
+		{
+			name: "binary body",
+			resp: CachedResponse{
+				Status:  200,
+				Headers: []Header{{Key: "Content-Type", Value: "application/octet-stream"}},
+				Body:    binary,
+				Created: now,
+				Expires: now.Add(time.Minute),
+			},
+		},
+		{
+			name: "repeated headers keep their order",
+			resp: CachedResponse{
+				Status: 200,
+				Headers: []Header{
+					{Key: "Set-Cookie", Value: "a=1"},
+					{Key: "Content-Type", Value: "text/plain"},
+					{Key: "Set-Cookie", Value: "b=2"},
+				},
+				Body: []byte("hello"),
+			},
+		},
+		{
+			name: "all fields",
+			resp: CachedResponse{
+				Status:               301,
+				Headers:              []Header{{Key: "Location", Value: "/"}},
+				Created:              now,
+				Expires:              now.Add(time.Hour),
+				Vary:                 []string{"Accept-Language", "Origin"},
+				ETag:                 `"v1"`,
+				LastModified:         "Wed, 01 Oct 2025 00:00:00 GMT",
+				StaleWhileRevalidate: now.Add(2 * time.Hour),
+				StaleIfError:         now.Add(3 * time.Hour),
+				Tags:                 []string{"home", "news"},
+			},
+		},
+		{
+			name:     "compressed body",
+			resp:     CachedResponse{Status: 200, Body: bytes.Repeat([]byte("compressible "), 1000)},
+			compress: true,
+		},
+		{
+			name:     "small body isn't compressed",
+			resp:     CachedResponse{Status: 200, Body: []byte("tiny")},
+			compress: true,
+		},
+		{
+			name:     "incompressible binary body",
+			resp:     CachedResponse{Status: 200, Body: bytes.Repeat(binary, 8)},
+			compress: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encodeCachedResponse(tt.resp, tt.compress)
+
+			got, err := decodeCachedResponse(data)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+
+			if got.Status != tt.resp.Status {
+				t.Errorf("status = %d, want %d", got.Status, tt.resp.Status)
+			}
+			if !reflect.DeepEqual(got.Headers, tt.resp.Headers) {
+				t.Errorf("headers = %v, want %v", got.Headers, tt.resp.Headers)
+			}
+			if !bytes.Equal(got.Body, tt.resp.Body) {
+				t.Errorf("body differs: got %d bytes, want %d", len(got.Body), len(tt.resp.Body))
+			}
+			if !got.Created.Equal(tt.resp.Created) || !got.Expires.Equal(tt.resp.Expires) {
+				t.Errorf("times = %v/%v, want %v/%v", got.Created, got.Expires, tt.resp.Created, tt.resp.Expires)
+			}
+			if !got.StaleWhileRevalidate.Equal(tt.resp.StaleWhileRevalidate) || !got.StaleIfError.Equal(tt.resp.StaleIfError) {
+				t.Errorf("stale times = %v/%v, want %v/%v", got.StaleWhileRevalidate, got.StaleIfError, tt.resp.StaleWhileRevalidate, tt.resp.StaleIfError)
+			}
+			if !reflect.DeepEqual(got.Vary, tt.resp.Vary) || !reflect.DeepEqual(got.Tags, tt.resp.Tags) {
+				t.Errorf("vary/tags = %v/%v, want %v/%v", got.Vary, got.Tags, tt.resp.Vary, tt.resp.Tags)
+			}
+			if got.ETag != tt.resp.ETag || got.LastModified != tt.resp.LastModified {
+				t.Errorf("validators = %q/%q, want %q/%q", got.ETag, got.LastModified, tt.resp.ETag, tt.resp.LastModified)
+			}
+		})
+	}
+}
+
+func TestCompressedBodyIsSmaller(t *testing.T) {
+	resp := CachedResponse{Status: 200, Body: bytes.Repeat([]byte("compressible "), 1000)}
+
+	plain := encodeCachedResponse(resp, false)
+	compressed := encodeCachedResponse(resp, true)
+
+	if compressed[1]&flagCompressedBody == 0 {
+		t.Fatal("body wasn't compressed")
+	}
+	if len(compressed) >= len(plain) {
+		t.Errorf("compressed = %d bytes, plain = %d bytes", len(compressed), len(plain))
+	}
+}
+
+func TestDecodeInvalidCachedResponse(t *testing.T) {
+	valid := encodeCachedResponse(CachedResponse{Status: 200, Body: []byte("hello")}, false)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"unknown version", append([]byte{cacheFormatVersion + 1}, valid[1:]...)},
+		{"truncated", valid[:len(valid)-3]},
+		{"json", []byte(`{"status":200}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCachedResponse(tt.data); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
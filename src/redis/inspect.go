@@ -0,0 +1,98 @@
+package redis
+
+import (
+	"strings"
+	"time"
+
+	rd "github.com/redis/go-redis/v9"
+)
+
+// CacheKeyInfo describes a key of the cache for the admin API: its remaining
+// TTL in seconds and its size in bytes. Variant keys of Vary responses are
+// listed too.
+type CacheKeyInfo struct {
+	Key  string `json:"key"`
+	TTL  int64  `json:"ttl"`
+	Size int64  `json:"size"`
+}
+
+// ListCacheKeys returns the cached keys of a subdomain whose URL starts with
+// prefix, with their remaining TTL and size. It returns at most limit keys (0
+// for all) starting at the SCAN cursor, and the cursor to continue from, 0
+// when there are no more.
+func ListCacheKeys(subdomain, prefix string, cursor uint64, limit int) ([]CacheKeyInfo, uint64, error) {
+	pattern := escapeGlob(CacheKey(subdomain, "GET", prefix)) + "*"
+	if prefix == "" {
+		pattern = escapeGlob(cacheKeyPrefix+subdomain+":") + "*"
+	}
+
+	keys := []string{}
+	for {
+		batch, next, err := rdb.Scan(ctx, cursor, pattern, 1000).Result()
+		if err != nil {
+			return nil, 0, err
+		}
+		keys = append(keys, batch...)
+		cursor = next
+
+		if cursor == 0 || (limit > 0 && len(keys) >= limit) {
+			break
+		}
+	}
+
+	pipe := rdb.Pipeline()
+	ttls := make([]*rd.DurationCmd, len(keys))
+	sizes := make([]*rd.IntCmd, len(keys))
+	for i, key := range keys {
+		ttls[i] = pipe.PTTL(ctx, key)
+		sizes[i] = pipe.StrLen(ctx, key)
+	}
+	if len(keys) != 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	infos := make([]CacheKeyInfo, 0, len(keys))
+	for i, key := range keys {
+		// Una clave que caduca entre el SCAN y el PTTL ya no existe
+		ttl := ttls[i].Val()
+		if ttl == -2 {
+			continue
+		}
+		if ttl > 0 {
+			ttl /= time.Second
+		}
+		infos = append(infos, CacheKeyInfo{Key: key, TTL: int64(ttl), Size: sizes[i].Val()})
+	}
+
+	return infos, cursor, nil
+}
+
+// GetCacheEntry reads a cached response straight from Redis, skipping the L1
+// cache, with its remaining TTL. Only keys of the cache can be read.
+func GetCacheEntry(key string) (CachedResponse, time.Duration, bool, error) {
+	if !strings.HasPrefix(key, cacheKeyPrefix) {
+		return CachedResponse{}, 0, false, nil
+	}
+
+	pipe := rdb.Pipeline()
+	get := pipe.Get(ctx, key)
+	pttl := pipe.PTTL(ctx, key)
+	pipe.Exec(ctx)
+
+	data, err := get.Bytes()
+	if err == rd.Nil {
+		return CachedResponse{}, 0, false, nil
+	}
+	if err != nil {
+		return CachedResponse{}, 0, false, err
+	}
+
+	resp, err := decodeCachedResponse(data)
+	if err != nil {
+		return CachedResponse{}, 0, false, err
+	}
+
+	return resp, pttl.Val(), true, nil
+}
@@ -0,0 +1,178 @@
+package redis
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The L1 cache keeps the most recently used responses in the memory of the
+// proxy so hot URLs don't need a Redis round trip. Every change to the shared
+// cache is announced on cacheInvalidationChannel so the other proxies drop
+// their copies.
+const cacheInvalidationChannel = "cache_invalidation"
+
+type l1Entry struct {
+	key   string
+	resp  CachedResponse
+	size  int64
+	until time.Time
+}
+
+type l1Cache struct {
+	mu           sync.Mutex
+	maxSize      int64
+	maxEntrySize int64
+	size         int64
+	order        *list.List
+	items        map[string]*list.Element
+}
+
+var l1 = &l1Cache{order: list.New(), items: map[string]*list.Element{}}
+
+// instanceID tells this proxy's own invalidation messages apart.
+var instanceID = newInstanceID()
+
+var subscribeOnce sync.Once
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SetupL1Cache sizes the in-memory cache; a maxSize of 0 disables it.
+// Responses bigger than maxEntrySize are only kept in Redis.
+func SetupL1Cache(maxSize, maxEntrySize int64) {
+	l1.mu.Lock()
+	l1.maxSize = maxSize
+	l1.maxEntrySize = maxEntrySize
+	l1.mu.Unlock()
+
+	l1.flush()
+
+	if maxSize > 0 {
+		subscribeOnce.Do(func() {
+			go listenCacheInvalidations()
+		})
+	}
+}
+
+func (l *l1Cache) get(key string) (CachedResponse, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.items[key]
+	if !ok {
+		return CachedResponse{}, false
+	}
+
+	entry := el.Value.(*l1Entry)
+	if time.Now().After(entry.until) {
+		l.removeElement(el)
+		return CachedResponse{}, false
+	}
+
+	l.order.MoveToFront(el)
+	return entry.resp, true
+}
+
+func (l *l1Cache) set(key string, resp CachedResponse, ttl time.Duration) {
+	size := responseSize(key, resp)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.items[key]; ok {
+		l.removeElement(el)
+	}
+
+	if l.maxSize <= 0 || ttl <= 0 || size > l.maxSize || (l.maxEntrySize > 0 && size > l.maxEntrySize) {
+		return
+	}
+
+	for l.size+size > l.maxSize {
+		l.removeElement(l.order.Back())
+	}
+
+	l.items[key] = l.order.PushFront(&l1Entry{key: key, resp: resp, size: size, until: time.Now().Add(ttl)})
+	l.size += size
+}
+
+func (l *l1Cache) remove(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		if el, ok := l.items[key]; ok {
+			l.removeElement(el)
+		}
+	}
+}
+
+func (l *l1Cache) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.order.Init()
+	l.items = map[string]*list.Element{}
+	l.size = 0
+}
+
+func (l *l1Cache) enabled() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.maxSize > 0
+}
+
+func (l *l1Cache) removeElement(el *list.Element) {
+	entry := el.Value.(*l1Entry)
+	l.order.Remove(el)
+	delete(l.items, entry.key)
+	l.size -= entry.size
+}
+
+// responseSize estimates the memory used by a cached response.
+func responseSize(key string, resp CachedResponse) int64 {
+	size := int64(len(key) + len(resp.Body) + 256)
+	for _, h := range resp.Headers {
+		size += int64(len(h.Key) + len(h.Value))
+	}
+	return size
+}
+
+// publishCacheInvalidation tells the other proxies to drop keys from their L1
+// cache. Without keys, the whole L1 cache is dropped.
+func publishCacheInvalidation(keys ...string) {
+	if len(keys) == 0 {
+		rdb.Publish(ctx, cacheInvalidationChannel, instanceID+"\tflush")
+		return
+	}
+
+	rdb.Publish(ctx, cacheInvalidationChannel, instanceID+"\tdel\t"+strings.Join(keys, "\n"))
+}
+
+// listenCacheInvalidations applies the invalidations of the other proxies.
+// go-redis resubscribes on its own if the connection drops.
+func listenCacheInvalidations() {
+	pubsub := rdb.Subscribe(ctx, cacheInvalidationChannel)
+
+	for msg := range pubsub.Channel() {
+		sender, payload, _ := strings.Cut(msg.Payload, "\t")
+		if sender == instanceID {
+			continue
+		}
+
+		op, keys, _ := strings.Cut(payload, "\t")
+		switch op {
+		case "flush":
+			l1.flush()
+		case "del":
+			l1.remove(strings.Split(keys, "\n")...)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package redis
+
+import (
+	"strings"
+	"time"
+)
+
+// Cached responses live under "cache:<subdomain>:<method>:<uri>", with the
+// variants of a Vary response under "<key>:vary:<hash>" and the compressed
+// bodies of a response under "<key>:enc:<encoding>". Tags are sets of keys
+// under "cache_tag:<tag>".
+const cacheKeyPrefix = "cache:"
+const cacheTagPrefix = "cache_tag:"
+
+func CacheKey(subdomain, method, uri string) string {
+	return cacheKeyPrefix + subdomain + ":" + method + ":" + uri
+}
+
+// EncodedCacheKey is where the body of the response stored under key is kept
+// compressed with encoding.
+func EncodedCacheKey(key, encoding string) string {
+	return key + ":enc:" + encoding
+}
+
+// TagCachedResponse records the key under each tag so it can be purged with
+// PurgeTag. The tag lives as long as its longest-lived key.
+func TagCachedResponse(key string, tags []string, ttl time.Duration) error {
+	for _, tag := range tags {
+		tagKey := cacheTagPrefix + tag
+		if err := rdb.SAdd(ctx, tagKey, key).Err(); err != nil {
+			return err
+		}
+		if ttl > CacheTTL(tagKey) {
+			if err := rdb.PExpire(ctx, tagKey, ttl).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PurgeURL removes a cached URL of a subdomain with all its variants.
+func PurgeURL(subdomain, uri string) (int, error) {
+	key := CacheKey(subdomain, "GET", uri)
+	return purgeMatching(escapeGlob(key), escapeGlob(key)+":vary:*", escapeGlob(key)+":enc:*")
+}
+
+// PurgePrefix removes every cached URL of a subdomain starting with prefix.
+func PurgePrefix(subdomain, prefix string) (int, error) {
+	return purgeMatching(escapeGlob(CacheKey(subdomain, "GET", prefix)) + "*")
+}
+
+// PurgeGlob removes the cached URLs of a subdomain matching a Redis glob
+// pattern ("/blog/*.html").
+func PurgeGlob(subdomain, pattern string) (int, error) {
+	key := escapeGlob(CacheKey(subdomain, "GET", "")) + pattern
+	return purgeMatching(key, key+":vary:*", key+":enc:*")
+}
+
+// PurgeSubdomain removes everything cached for a subdomain.
+func PurgeSubdomain(subdomain string) (int, error) {
+	return purgeMatching(escapeGlob(cacheKeyPrefix+subdomain+":") + "*")
+}
+
+// PurgeTag removes the responses stored with a Surrogate-Key or Cache-Tag.
+func PurgeTag(tag string) (int, error) {
+	tagKey := cacheTagPrefix + tag
+
+	keys, err := rdb.SMembers(ctx, tagKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := deleteCacheKeys(keys)
+	if err != nil {
+		return purged, err
+	}
+
+	return purged, rdb.Del(ctx, tagKey).Err()
+}
+
+func purgeMatching(patterns ...string) (int, error) {
+	purged := 0
+
+	for _, pattern := range patterns {
+		iter := rdb.Scan(ctx, 0, pattern, 1000).Iterator()
+
+		keys := []string{}
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			return purged, err
+		}
+
+		n, err := deleteCacheKeys(keys)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+
+	return purged, nil
+}
+
+func deleteCacheKeys(keys []string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	n, err := rdb.Del(ctx, keys...).Result()
+	if err != nil {
+		return int(n), err
+	}
+
+	l1.remove(keys...)
+	publishCacheInvalidation(keys...)
+
+	return int(n), forgetCacheUsage(keys)
+}
+
+func escapeGlob(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+	return replacer.Replace(s)
+}
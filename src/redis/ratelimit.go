@@ -0,0 +1,40 @@
+package redis
+
+import "time"
+
+// AllowRequest counts a request of ip to the subdomain in the current window
+// of the rate limit. It returns false once the IP has sent more than limit
+// requests, and for block afterwards when block is set.
+func AllowRequest(subdomain, ip string, limit int, window, block time.Duration) (bool, error) {
+	counterKey := "rate_limit:" + subdomain + ":" + ip
+	blockKey := "rate_limit_block:" + subdomain + ":" + ip
+
+	pipe := rdb.Pipeline()
+	blocked := pipe.Exists(ctx, blockKey)
+	count := pipe.Incr(ctx, counterKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return true, err
+	}
+
+	// La ventana empieza con la primera petición
+	if count.Val() == 1 {
+		if err := rdb.Expire(ctx, counterKey, window).Err(); err != nil {
+			return true, err
+		}
+	}
+
+	if blocked.Val() > 0 {
+		return false, nil
+	}
+	if count.Val() <= int64(limit) {
+		return true, nil
+	}
+
+	if block > 0 {
+		if err := rdb.Set(ctx, blockKey, true, block).Err(); err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
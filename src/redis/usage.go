@@ -0,0 +1,103 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	rd "github.com/redis/go-redis/v9"
+)
+
+// The usage of each subdomain is kept as entries are stored and removed, so
+// the stats don't have to scan the keyspace: "cache_usage:<subdomain>" ranks
+// the keys by expiry (ms), "cache_usage_size:<subdomain>" has their sizes and
+// "cache_usage_total:<subdomain>" the entries and bytes. Keys that Redis
+// expires are taken out the next time the usage is read.
+const (
+	usageIndexPrefix = "cache_usage:"
+	usageSizePrefix  = "cache_usage_size:"
+	usageTotalPrefix = "cache_usage_total:"
+)
+
+var recordUsageScript = rd.NewScript(`
+local old = redis.call('HGET', KEYS[2], ARGV[1])
+if old then
+	redis.call('HINCRBY', KEYS[3], 'bytes', tonumber(ARGV[2]) - tonumber(old))
+else
+	redis.call('HINCRBY', KEYS[3], 'bytes', ARGV[2])
+	redis.call('HINCRBY', KEYS[3], 'entries', 1)
+end
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+return 1
+`)
+
+// forgetUsageScript removes the keys expired by ARGV[1] and the keys in the
+// rest of ARGV, and returns the entries and bytes left.
+var forgetUsageScript = rd.NewScript(`
+local function forget(key)
+	local size = redis.call('HGET', KEYS[2], key)
+	if size then
+		redis.call('HDEL', KEYS[2], key)
+		redis.call('HINCRBY', KEYS[3], 'bytes', -tonumber(size))
+		redis.call('HINCRBY', KEYS[3], 'entries', -1)
+	end
+	redis.call('ZREM', KEYS[1], key)
+end
+
+for _, key in ipairs(redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])) do
+	forget(key)
+end
+for i = 2, #ARGV do
+	forget(ARGV[i])
+end
+
+local total = redis.call('HMGET', KEYS[3], 'entries', 'bytes')
+return {tonumber(total[1]) or 0, tonumber(total[2]) or 0}
+`)
+
+func usageKeys(subdomain string) []string {
+	return []string{usageIndexPrefix + subdomain, usageSizePrefix + subdomain, usageTotalPrefix + subdomain}
+}
+
+// keySubdomain returns the subdomain of a cache key.
+func keySubdomain(key string) string {
+	subdomain, _, _ := strings.Cut(strings.TrimPrefix(key, cacheKeyPrefix), ":")
+	return subdomain
+}
+
+func recordCacheUsage(key string, size int, ttl time.Duration) error {
+	expires := "+inf"
+	if ttl > 0 {
+		expires = strconv.FormatInt(time.Now().Add(ttl).UnixMilli(), 10)
+	}
+
+	return recordUsageScript.Run(ctx, rdb, usageKeys(keySubdomain(key)), key, size, expires).Err()
+}
+
+func forgetCacheUsage(keys []string) error {
+	bySubdomain := map[string][]any{}
+	for _, key := range keys {
+		subdomain := keySubdomain(key)
+		bySubdomain[subdomain] = append(bySubdomain[subdomain], key)
+	}
+
+	for subdomain, args := range bySubdomain {
+		// Con 0 como límite solo se quitan las claves indicadas
+		if err := forgetUsageScript.Run(ctx, rdb, usageKeys(subdomain), append([]any{0}, args...)...).Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CacheUsage returns how many keys and bytes the subdomain takes in Redis.
+func CacheUsage(subdomain string) (int, int64, error) {
+	result, err := forgetUsageScript.Run(ctx, rdb, usageKeys(subdomain), time.Now().UnixMilli()).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int(result[0]), result[1], nil
+}